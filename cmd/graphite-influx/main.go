@@ -0,0 +1,153 @@
+// Command graphite-influx migrates Graphite whisper files to InfluxDB and has
+// tools to look into TSM and whisper files
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/uttamgandhi/graphite-influx/config"
+	"github.com/uttamgandhi/graphite-influx/migration"
+	"github.com/uttamgandhi/graphite-influx/tools"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(fs *flag.FlagSet, args []string)
+}
+
+var commands = []command{
+	{"migrate", "Migrate whisper files to InfluxDB", migration.Run},
+	{"tsm inspect", "Print the keys of a TSM file or the values of a key", tsmInspect},
+	{"tsm write", "Write a TSM file with a single value", tsmWrite},
+	{"whisper dump", "Print the points of a whisper file", whisperDump},
+	{"seed", "Write a month of dummy data through the HTTP API", seed},
+	{"selftest", "Write and read back a TSM file", selftest},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `graphite-influx [-logfile=file] [-quiet] <command> [flags]
+
+Commands:`)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, `
+Run graphite-influx <command> -h for the flags of a command`)
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	logFile := flag.String("logfile", "", "Write the log to this file instead of stderr")
+	quiet := flag.Bool("quiet", false, "Do not log")
+	flag.Parse()
+
+	switch {
+	case *quiet:
+		log.SetOutput(ioutil.Discard)
+	case *logFile != "":
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			log.Fatal("Error in opening log file ", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	args := flag.Args()
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) ||
+			strings.Join(args[:len(words)], " ") != cmd.name {
+			continue
+		}
+		fs := flag.NewFlagSet("graphite-influx "+cmd.name, flag.ExitOnError)
+		cmd.run(fs, args[len(words):])
+		return
+	}
+	usage()
+}
+
+// Parse the flags of a command, the required string flags must not be empty
+func parse(fs *flag.FlagSet, args []string, required ...string) {
+	fs.Parse(args)
+	for _, name := range required {
+		if fs.Lookup(name).Value.String() == "" {
+			fmt.Fprintf(os.Stderr, "-%s is required\n", name)
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+}
+
+func tsmInspect(fs *flag.FlagSet, args []string) {
+	filename := fs.String("file", "", "InfluxDB TSM filename")
+	key := fs.String("key", "", "Key name, e.g. cpu_usage,tag2=value2#!~#idle; all keys and the time range if empty")
+	parse(fs, args, "file")
+
+	if err := tools.InspectTSM(os.Stdout, *filename, *key); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func tsmWrite(fs *flag.FlagSet, args []string) {
+	filename := fs.String("file", "", "InfluxDB TSM filename")
+	key := fs.String("key", "", "Key name, e.g. cpu_usage,tag2=value2#!~#idle")
+	timestamp := fs.Int64("ts", time.Now().Unix(), "Unix epoch time, default: now")
+	value := fs.Float64("val", 0.0, "Value to be stored")
+	parse(fs, args, "file", "key")
+
+	err := tools.WriteTSMValue(*filename, *key, time.Unix(*timestamp, 0), *value)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func whisperDump(fs *flag.FlagSet, args []string) {
+	now := time.Now()
+	filename := fs.String("file", "", "Graphite whisper filename")
+	from := fs.Int64("from", now.Add(-24*time.Hour).Unix(), "Unix epoch time of the beginning of the requested interval, default: 24 hours ago")
+	until := fs.Int64("until", now.Unix(), "Unix epoch time of the end of the requested interval, default: now")
+	parse(fs, args, "file")
+
+	err := tools.DumpWhisper(os.Stdout, *filename, time.Unix(*from, 0),
+		time.Unix(*until, 0))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func seed(fs *flag.FlagSet, args []string) {
+	database := fs.String("db", "mydb2", "Database, it has to exist")
+	measurement := fs.String("measurement", "edepu3_usage", "Measurement of the dummy data")
+	var influxConfig config.InfluxConfig
+	influxConfig.RegisterFlags(fs)
+	parse(fs, args)
+	influxConfig.ReadEnv(fs)
+
+	c, err := influxConfig.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if err := tools.Seed(c, *database, *measurement); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func selftest(fs *flag.FlagSet, args []string) {
+	dir := fs.String("dir", os.TempDir(), "Directory of the test TSM file")
+	parse(fs, args)
+
+	if err := tools.SelfTest(*dir); err != nil {
+		log.Fatal("Selftest failed: ", err)
+	}
+	fmt.Println("Selftest passed")
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// httpClient is a client of the InfluxDB HTTP API sending its requests with
+// the http.Client of HTTPClient, as the InfluxDB client has no TLS settings
+// but InsecureSkipVerify. Queries and writes are made like the InfluxDB
+// client makes them
+type httpClient struct {
+	config     InfluxConfig
+	url        *url.URL
+	httpClient *http.Client
+}
+
+func (c *httpClient) request(method string, path string, params url.Values,
+	body string) (*http.Response, error) {
+
+	u := *c.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.config.UserAgent)
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	return c.httpClient.Do(req)
+}
+
+// Write the points as line protocol
+func (c *httpClient) Write(bp client.BatchPoints) error {
+	var lines bytes.Buffer
+	for _, pt := range bp.Points() {
+		lines.WriteString(pt.PrecisionString(bp.Precision()))
+		lines.WriteByte('\n')
+	}
+	params := url.Values{}
+	params.Set("db", bp.Database())
+	params.Set("rp", bp.RetentionPolicy())
+	params.Set("precision", bp.Precision())
+	params.Set("consistency", bp.WriteConsistency())
+	resp, err := c.request("POST", "write", params, lines.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("%v: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Query InfluxDB, numbers in the response are decoded as json.Number
+func (c *httpClient) Query(q client.Query) (*client.Response, error) {
+	params := url.Values{}
+	params.Set("q", q.Command)
+	params.Set("db", q.Database)
+	if q.Precision != "" {
+		params.Set("epoch", q.Precision)
+	}
+	resp, err := c.request("GET", "query", params, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response client.Response
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&response)
+	//An error status may come without a body
+	if err != nil && !(err.Error() == "EOF" && resp.StatusCode != http.StatusOK) {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && response.Error() == nil {
+		return &response, fmt.Errorf("received status code %d from server",
+			resp.StatusCode)
+	}
+	return &response, nil
+}
+
+func (c *httpClient) Close() error {
+	return nil
+}
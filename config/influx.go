@@ -0,0 +1,116 @@
+// Package config holds the settings shared by the graphite-influx commands
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Connection settings of the InfluxDB HTTP API, shared by everything which
+// creates a client. The flags default to the INFLUX_* environment variables so
+// the password does not have to be on the command line, the credentials are
+// read from the environment by ReadEnv so -h does not print them
+type InfluxConfig struct {
+	Addr               string
+	Username           string
+	Password           string
+	CACert             string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	UserAgent          string
+}
+
+// Register the -influx* flags on a flag set
+func (config *InfluxConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&config.Addr, "influxAddr",
+		envOrDefault("INFLUX_ADDR", "http://localhost:8086"),
+		"InfluxDB HTTP API address, env INFLUX_ADDR")
+	fs.StringVar(&config.Username, "influxUsername", "",
+		"InfluxDB username, env INFLUX_USERNAME")
+	fs.StringVar(&config.Password, "influxPassword", "",
+		"InfluxDB password, env INFLUX_PASSWORD")
+	fs.StringVar(&config.CACert, "influxCACert", os.Getenv("INFLUX_CA_CERT"),
+		"PEM file of the CA to verify the InfluxDB certificate, env INFLUX_CA_CERT")
+	fs.BoolVar(&config.InsecureSkipVerify, "influxInsecureSkipVerify", false,
+		"Do not verify the InfluxDB certificate")
+	fs.DurationVar(&config.Timeout, "influxTimeout", 0,
+		"Timeout of InfluxDB requests, 0 for none")
+	fs.StringVar(&config.UserAgent, "influxUserAgent", "graphite-influx",
+		"User agent of InfluxDB requests")
+}
+
+// Read the username and password from INFLUX_USERNAME and INFLUX_PASSWORD
+// unless their flags are set, call it after parsing the flags
+func (config *InfluxConfig) ReadEnv(fs *flag.FlagSet) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["influxUsername"] {
+		config.Username = os.Getenv("INFLUX_USERNAME")
+	}
+	if !set["influxPassword"] {
+		config.Password = os.Getenv("INFLUX_PASSWORD")
+	}
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Create a client of the InfluxDB HTTP API, it verifies the InfluxDB
+// certificate with -influxCACert
+func (config *InfluxConfig) NewClient() (client.Client, error) {
+	u, err := url.Parse(config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported protocol scheme %q in %v, the address must start with http:// or https://",
+			u.Scheme, config.Addr)
+	}
+	plainClient, err := config.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &httpClient{config: *config, url: u, httpClient: plainClient}, nil
+}
+
+// Create a plain HTTP client with the TLS settings and timeout
+func (config *InfluxConfig) HTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CACert != "" {
+		certPool, err := config.certPool()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	return &http.Client{Timeout: config.Timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// Read the CA certificates of -influxCACert
+func (config *InfluxConfig) certPool() (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(config.CACert)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %v", config.CACert)
+	}
+	return certPool, nil
+}
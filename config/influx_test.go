@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Queries are verified with the CA of -influxCACert
+func TestNewClientCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		if r.URL.Path != "/query" || r.URL.Query().Get("q") != "SHOW DATABASES" {
+			t.Errorf("unexpected request %v", r.URL)
+		}
+		if username, password, _ := r.BasicAuth(); username != "admin" ||
+			password != "secret" {
+			t.Errorf("unexpected credentials %q %q", username, password)
+		}
+		w.Write([]byte(`{"results":[{"series":[{"name":"databases","columns":["name"],"values":[["migrated"]]}]}]}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "graphite-influx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caCert := filepath.Join(dir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caCert, certPEM, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		caCert string
+		fail   bool
+	}{
+		{name: "system roots", fail: true},
+		{name: "CA certificate", caCert: caCert},
+	}
+	for _, test := range tests {
+		config := InfluxConfig{Addr: server.URL, Username: "admin",
+			Password: "secret", CACert: test.caCert}
+		c, err := config.NewClient()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		response, err := c.Query(client.NewQuery("SHOW DATABASES", "", ""))
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected a certificate error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(response.Results) != 1 || len(response.Results[0].Series) != 1 {
+			t.Errorf("%s: unexpected response %+v", test.name, response)
+		}
+	}
+}
+
+func TestNewClientAddr(t *testing.T) {
+	config := InfluxConfig{Addr: "udp://localhost:8089"}
+	if _, err := config.NewClient(); err == nil {
+		t.Error("expected an error for a udp address")
+	}
+}
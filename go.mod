@@ -0,0 +1,26 @@
+module github.com/uttamgandhi/graphite-influx
+
+go 1.17
+
+require (
+	github.com/BurntSushi/toml v0.3.0
+	// Last release before the move to github.com/influxdata/influxdb
+	github.com/influxdb/influxdb v0.10.0
+)
+
+// influxdb 0.10 has no go.mod, its dependencies are pinned to versions with
+// the APIs it was built against. services/meta needs raft before 1.0
+require (
+	github.com/armon/go-metrics v0.0.0-20150601112433-b2d95e5291cd // indirect
+	github.com/boltdb/bolt v1.3.1 // indirect
+	github.com/dgryski/go-bits v0.0.0-20180113010104-bd8a69a71dc2 // indirect
+	github.com/dgryski/go-bitstream v0.0.0-20180413035011-3522498ce2c8 // indirect
+	github.com/gogo/protobuf v1.1.1 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/hashicorp/go-msgpack v0.0.0-20150518234257-fa3f63826f7c // indirect
+	github.com/hashicorp/raft v0.0.0-20160603202243-4bcac2adb069 // indirect
+	github.com/hashicorp/raft-boltdb v0.0.0-20150201200839-d1e82c1ec3f1 // indirect
+	github.com/jwilder/encoding v0.0.0-20170811194829-b4e1701a28ef // indirect
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871 // indirect
+	gopkg.in/fatih/pool.v2 v2.0.0 // indirect
+)
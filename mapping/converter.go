@@ -0,0 +1,178 @@
+package mapping
+
+import (
+	"math"
+	"time"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// Series is a part of the points of a whisper file, mapped to an InfluxDB
+// series
+type Series struct {
+	WspFile    string
+	MetricName string
+	// TSM key of the series, see CreateTSMKey
+	Key string
+	MTF *MTF
+	// Values sorted by time
+	Values []tsm1.Value
+	// Number of null and stale whisper slots dropped from the values
+	Skipped int
+}
+
+// SeriesFunc receives the series of a whisper file, it can return ErrStop to
+// end the conversion
+type SeriesFunc func(series Series) error
+
+// Converter streams the points of whisper files as series of the Mapper. A
+// whisper file is fetched in chunks, every chunk from the archive with the
+// highest resolution covering it, and the callback is called once per chunk
+// with points
+type Converter struct {
+	mapper *Mapper
+	// Convert the points in [From, Until], the zero times for all
+	From  time.Time
+	Until time.Time
+	// Time span fetched at once, the whole file if 0
+	Chunk time.Duration
+}
+
+// Create a Converter of all points, fetched a day at a time
+func NewConverter(mapper *Mapper) *Converter {
+	return &Converter{mapper: mapper, Chunk: 24 * time.Hour}
+}
+
+// Convert a whisper file, metricName is mapped with the Mapper. Returns the
+// error of the Mapper, a *FileError if the file can not be read or the error
+// of fn
+func (converter *Converter) Convert(wspFile string, metricName string,
+	fn SeriesFunc) error {
+
+	mtf, err := converter.mapper.Map(metricName)
+	if err != nil {
+		return err
+	}
+	w, err := whisper.Open(wspFile)
+	if err != nil {
+		return &FileError{File: wspFile, Err: err}
+	}
+	defer w.Close()
+
+	oldest, err := w.GetOldest()
+	if err != nil {
+		return &FileError{File: wspFile, Err: err}
+	}
+	now := time.Now()
+	until := converter.Until
+	if until.IsZero() {
+		until = now
+	}
+	from, until, ok := ClampToWhisper(converter.From, until,
+		time.Unix(int64(oldest), 0), now)
+	if !ok {
+		return nil
+	}
+
+	key := CreateTSMKey(mtf)
+	for start := from; start.Before(until); {
+		end := until
+		if converter.Chunk > 0 && start.Add(converter.Chunk).Before(until) {
+			end = start.Add(converter.Chunk)
+		}
+		points, _, skipped, err := FetchPoints(w, start, end, now)
+		if err != nil {
+			return &FileError{File: wspFile, Err: err}
+		}
+		//A chunk includes its end, the next one starts a second later
+		start = end.Add(time.Second)
+		if len(points) == 0 {
+			continue
+		}
+		err = fn(Series{WspFile: wspFile, MetricName: metricName, Key: key,
+			MTF: mtf, Values: NewValues(points), Skipped: skipped})
+		if err == ErrStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the valid points of a whisper file in [from, until] clamped to the range
+// the file holds data for, fetched from the archive with the highest
+// resolution covering from. Returns the points, the step of the archive and
+// the number of null and stale slots skipped
+func FetchPoints(w *whisper.Whisper, from time.Time, until time.Time,
+	now time.Time) ([]whisper.Point, uint32, int, error) {
+
+	oldest, err := w.GetOldest()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	from, until, ok := ClampToWhisper(from, until, time.Unix(int64(oldest), 0),
+		now)
+	if !ok {
+		return nil, 0, 0, nil
+	}
+	//Whisper returns the points after the start, a second earlier the point
+	//at from is included
+	interval, points, err := w.FetchUntilTime(from.Add(-time.Second), until)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	points, skipped := ValidPoints(points, interval.Step, interval.FromTimestamp,
+		interval.UntilTimestamp)
+	return points, interval.Step, skipped, nil
+}
+
+// Map whisper points to TSM values
+func NewValues(points []whisper.Point) []tsm1.Value {
+	values := make([]tsm1.Value, len(points))
+	for i, point := range points {
+		values[i] = tsm1.NewValue(time.Unix(int64(point.Timestamp), 0),
+			point.Value)
+	}
+	return values
+}
+
+// Drop the null and stale slots of whisper points. Whisper archives are ring
+// buffers: slots never written have timestamp 0, slots left from an earlier
+// lap have a timestamp older than the range read. A slot is valid when its
+// timestamp is a multiple of the step of the archive within [from, until] and
+// its value is a number. Returns the valid points and the number skipped
+func ValidPoints(points []whisper.Point, step uint32, from uint32,
+	until uint32) ([]whisper.Point, int) {
+
+	valid := make([]whisper.Point, 0, len(points))
+	for _, point := range points {
+		if point.Timestamp == 0 || point.Timestamp < from ||
+			point.Timestamp > until || math.IsNaN(point.Value) {
+			continue
+		}
+		if step > 0 && point.Timestamp%step != 0 {
+			continue
+		}
+		valid = append(valid, point)
+	}
+	return valid, len(points) - len(valid)
+}
+
+// Clamp a time range to [max(from, oldest), min(until, now)], the range a
+// whisper file with the given oldest timestamp holds data for. A file whose
+// retention starts after from still has the rest of the range. Returns false
+// if the range and the file do not overlap
+func ClampToWhisper(from time.Time, until time.Time, oldest time.Time,
+	now time.Time) (time.Time, time.Time, bool) {
+
+	if from.Before(oldest) {
+		from = oldest
+	}
+	if until.After(now) {
+		until = now
+	}
+	return from, until, from.Before(until)
+}
@@ -0,0 +1,50 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors of the package are stable, callers can compare with them and
+// switch on the error types
+var (
+	// ErrNoMatch is returned by Mapper.Map when no TagConfig matches the
+	// metric name
+	ErrNoMatch = errors.New("mapping: no tag config matches the metric name")
+	// ErrInvalidSeries is returned by Mapper.Map when a metric name maps to an
+	// empty measurement or field
+	ErrInvalidSeries = errors.New("mapping: empty measurement or field")
+	// ErrStop can be returned by a SeriesFunc to end Converter.Convert early,
+	// Convert then returns nil
+	ErrStop = errors.New("mapping: stop")
+)
+
+// PatternError is returned by NewMapper and Mapper.Add for a TagConfig whose
+// pattern does not compile
+type PatternError struct {
+	Pattern string
+	Err     error
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("mapping: invalid pattern %q: %v", e.Pattern, e.Err)
+}
+
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+// FileError is returned by Converter.Convert when a whisper file can not be
+// opened or read
+type FileError struct {
+	File string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("mapping: whisper file %v: %v", e.File, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
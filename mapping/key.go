@@ -0,0 +1,97 @@
+package mapping
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/influxdb/influxdb/models"
+)
+
+// Separator of series key and field in the keys of TSM files
+const KeyFieldSeparator = "#!~#"
+
+// File extension of whisper files
+const WhisperExtension = ".wsp"
+
+// Create TSM Key from measurement, tags and field, the series key is escaped
+// as in line protocol
+func CreateTSMKey(mtf *MTF) string {
+	return SeriesKey(mtf) + KeyFieldSeparator + mtf.Field
+}
+
+// Series key of measurement and tags escaped like InfluxDB does, with the
+// tags sorted by key and tags with empty values left out
+func SeriesKey(mtf *MTF) string {
+	tags := make(models.Tags)
+	for _, tagKeyValue := range mtf.Tags {
+		tags[tagKeyValue.Tagkey] = tagKeyValue.Tagvalue
+	}
+	return string(models.MakeKey([]byte(mtf.Measurement), tags))
+}
+
+// Get the dotted graphite metric name of a whisper file relative to the
+// whisper root directory, e.g. with root /data/whisper
+// /data/whisper/carbon/agents/host1/cpu.wsp -> carbon.agents.host1.cpu
+func MetricName(root string, wspFile string) string {
+	if rel, err := filepath.Rel(root, wspFile); err == nil {
+		wspFile = rel
+	}
+	wspFile = strings.TrimSuffix(wspFile, WhisperExtension)
+	return strings.Replace(wspFile, string(filepath.Separator), ".", -1)
+}
+
+// Sort the tags by key and sanitize the names if asked to, so equal series
+// get equal keys. The MTF is copied
+func NormalizeMTF(mtf *MTF, sanitize bool) *MTF {
+	if mtf == nil {
+		return nil
+	}
+	normalized := &MTF{Measurement: mtf.Measurement, Field: mtf.Field,
+		Tags: append([]TagKeyValue{}, mtf.Tags...)}
+	if sanitize {
+		normalized.Measurement = SanitizeName(normalized.Measurement)
+		normalized.Field = SanitizeName(normalized.Field)
+		for i := range normalized.Tags {
+			normalized.Tags[i].Tagkey = SanitizeName(normalized.Tags[i].Tagkey)
+			normalized.Tags[i].Tagvalue = SanitizeName(normalized.Tags[i].Tagvalue)
+		}
+	}
+	sort.Stable(byTagKey(normalized.Tags))
+	return normalized
+}
+
+// Replace the characters which line protocol needs escaped, control
+// characters and invalid UTF-8 with _
+func SanitizeName(name string) string {
+	if !utf8.ValidString(name) {
+		var valid []rune
+		for i, r := range name {
+			if r == utf8.RuneError {
+				if _, size := utf8.DecodeRuneInString(name[i:]); size == 1 {
+					r = '_'
+				}
+			}
+			valid = append(valid, r)
+		}
+		name = string(valid)
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', ' ', '=', '"', '\\':
+			return '_'
+		}
+		if unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+type byTagKey []TagKeyValue
+
+func (a byTagKey) Len() int           { return len(a) }
+func (a byTagKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTagKey) Less(i, j int) bool { return a[i].Tagkey < a[j].Tagkey }
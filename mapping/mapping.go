@@ -0,0 +1,229 @@
+// Package mapping maps Graphite metric names to InfluxDB series and converts
+// the points of whisper files to them.
+//
+// A Mapper is built from TagConfigs, the format of the migration's
+// -tagconfig file, and maps a dotted metric name to measurement, tags and
+// field:
+//
+//	mapper, err := mapping.NewMapper(tagConfigs)
+//	mtf, err := mapper.Map("servers.dc1.host1.cpu")
+//
+// A Converter reads a whisper file and hands its points to a callback as
+// series with the TSM key of the mapped series:
+//
+//	converter := mapping.NewConverter(mapper)
+//	err := converter.Convert(wspFile, metricName, func(series mapping.Series) error {
+//		return write(series.Key, series.Values)
+//	})
+//
+// The errors returned are ErrNoMatch, ErrInvalidSeries, *PatternError,
+// *FileError and the errors of the callback
+package mapping
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A tag of a series
+type TagKeyValue struct {
+	Tagkey   string `json:"tagkey"`
+	Tagvalue string `json:"tagvalue"`
+}
+
+// TagConfig maps whisper files to measurement, tags and field. Pattern is
+// either the #TEXTn form (e.g. carbon.agents.#TEXT1.#TEXT2) or a regular
+// expression with named capture groups, e.g.
+// ^carbon\.agents\.(?P<host>[^.]+)\.(?P<measurement>.+)$
+// In the regex form Measurement, Tags and Field refer to the groups as #name
+type TagConfig struct {
+	Pattern     string        `json:"pattern"`
+	Measurement string        `json:"measurement"`
+	Tags        []TagKeyValue `json:"tags"`
+	Field       string        `json:"field"`
+	re          *regexp.Regexp
+	regex       bool
+}
+
+// Measurement, tags and field of a series
+type MTF struct {
+	Measurement string
+	Tags        []TagKeyValue
+	Field       string
+}
+
+// Mapper maps metric names with TagConfigs, the first TagConfig whose pattern
+// matches wins. Map can be called concurrently, but not together with Add
+type Mapper struct {
+	tagConfigs []TagConfig
+	// Replace the characters which need escaping in series keys with _
+	Sanitize bool
+}
+
+// Create a Mapper, the patterns of the TagConfigs are compiled up front
+func NewMapper(tagConfigs []TagConfig) (*Mapper, error) {
+	mapper := &Mapper{}
+	for _, tagConfig := range tagConfigs {
+		if err := mapper.Add(tagConfig); err != nil {
+			return nil, err
+		}
+	}
+	return mapper, nil
+}
+
+// Add a TagConfig after the others
+func (mapper *Mapper) Add(tagConfig TagConfig) error {
+	if _, err := tagConfig.Regexp(); err != nil {
+		return &PatternError{Pattern: tagConfig.Pattern, Err: err}
+	}
+	mapper.tagConfigs = append(mapper.tagConfigs, tagConfig)
+	return nil
+}
+
+// The TagConfigs of the Mapper, e.g. to write them back to a config file
+func (mapper *Mapper) TagConfigs() []TagConfig {
+	return append([]TagConfig{}, mapper.tagConfigs...)
+}
+
+// Get measurement, tags and field of a metric name, the tags are sorted by
+// key. Returns ErrNoMatch if no pattern matches and ErrInvalidSeries if the
+// measurement or field is empty
+func (mapper *Mapper) Map(metricName string) (*MTF, error) {
+	mtf, _ := mapper.Match(metricName)
+	if mtf == nil {
+		return nil, ErrNoMatch
+	}
+	if mtf.Measurement == "" || mtf.Field == "" {
+		return nil, ErrInvalidSeries
+	}
+	return mtf, nil
+}
+
+// Get measurement, tags and field of a metric name and the pattern which
+// matched it, nil if nothing matched
+func (mapper *Mapper) Match(metricName string) (*MTF, string) {
+	for i := range mapper.tagConfigs {
+		tagConfig := &mapper.tagConfigs[i]
+		re, err := tagConfig.Regexp()
+		if err != nil {
+			continue
+		}
+		if tagConfig.IsRegex() {
+			if submatches := re.FindStringSubmatch(metricName); submatches != nil {
+				return NormalizeMTF(tagConfig.RegexMTF(re, submatches),
+					mapper.Sanitize), tagConfig.Pattern
+			}
+			continue
+		}
+		//FindAllIndex returns array of start and end index of the match
+		if matches := re.FindAllIndex([]byte(metricName), -1); matches != nil {
+			return NormalizeMTF(tagConfig.TextMTF(metricName, matches),
+				mapper.Sanitize), tagConfig.Pattern
+		}
+	}
+	return nil, ""
+}
+
+// Regex patterns are recognised by their named capture groups, (?P<name>...)
+// or (?<name>...)
+func (tagConfig *TagConfig) IsRegex() bool {
+	tagConfig.Regexp()
+	return tagConfig.regex
+}
+
+// Get the compiled pattern. A pattern which compiles with named capture
+// groups is a regex pattern, for #TEXTn patterns only the part before the
+// first # is compiled
+func (tagConfig *TagConfig) Regexp() (*regexp.Regexp, error) {
+	if tagConfig.re != nil {
+		return tagConfig.re, nil
+	}
+	if re, err := regexp.Compile(tagConfig.Pattern); err == nil {
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				tagConfig.re = re
+				tagConfig.regex = true
+				return re, nil
+			}
+		}
+	}
+	re, err := regexp.Compile(strings.Split(tagConfig.Pattern, "#")[0])
+	if err != nil {
+		return nil, err
+	}
+	tagConfig.re = re
+	return re, nil
+}
+
+var captureRefRegexp = regexp.MustCompile(`#(\w+)`)
+
+// Get measurement, tags and field from the named capture groups of a regex
+// pattern, every #name in measurement, tag values and field is replaced with
+// the value captured by the group of that name
+func (tagConfig *TagConfig) RegexMTF(re *regexp.Regexp,
+	submatches []string) *MTF {
+
+	captures := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			captures[name] = submatches[i]
+		}
+	}
+	expand := func(template string) string {
+		return captureRefRegexp.ReplaceAllStringFunc(template, func(ref string) string {
+			if value, ok := captures[ref[1:]]; ok {
+				return value
+			}
+			return ref
+		})
+	}
+
+	var mtf MTF
+	mtf.Measurement = expand(tagConfig.Measurement)
+	mtf.Tags = make([]TagKeyValue, len(tagConfig.Tags))
+	for i, tagkeyvalue := range tagConfig.Tags {
+		mtf.Tags[i].Tagkey = expand(tagkeyvalue.Tagkey)
+		mtf.Tags[i].Tagvalue = expand(tagkeyvalue.Tagvalue)
+	}
+	mtf.Field = expand(tagConfig.Field)
+	return &mtf
+}
+
+// Get measurement, tags and field for a #TEXTn pattern, matches holds the
+// start and end index of the pattern prefix in metricName
+func (tagConfig *TagConfig) TextMTF(metricName string, matches [][]int) *MTF {
+	//patternStr contains pattern split on #
+	//e.g. patternStr[0]carbon.relays. , patternStr[1]TEXT1. , patternStr[2]TEXT2.
+	patternStr := strings.Split(tagConfig.Pattern, "#")
+
+	//extract the string starting at end of the matched pattern
+	//e.g. carbon.relays.eud3-pr-mutgra1-a.whitelistRejects,
+	// the remaining would be eud3-pr-mutgra1-a.whitelistRejects
+	remaining := metricName[matches[0][1]:]
+
+	//Split the remaining string on .
+	//e.g. Now the remArr holds eud3-pr-mutgra1-a, whitelistRejects
+	remArr := strings.Split(remaining, ".")
+
+	var mtf MTF
+	mtf.Tags = make([]TagKeyValue, len(tagConfig.Tags))
+
+	//start at i=1, that's #TEXT1 and iterate on all possible # strings in given
+	// pattern
+	for i := 1; i < len(patternStr)-1; i++ {
+		patternTagValue := strings.Trim(patternStr[i], ".")
+		//For each # string, find a match in tag values
+		for j, tagkeyvalue := range tagConfig.Tags {
+			if strings.Trim(tagkeyvalue.Tagvalue, "#") == patternTagValue &&
+				i-1 < len(remArr) {
+				mtf.Tags[j].Tagkey = tagkeyvalue.Tagkey
+				//Tag #value is replaced with the actual value
+				mtf.Tags[j].Tagvalue = remArr[i-1]
+			}
+		}
+	}
+	// Assign the last string as measurement
+	mtf.Measurement = remArr[len(remArr)-1]
+	mtf.Field = tagConfig.Field
+	return &mtf
+}
@@ -0,0 +1,214 @@
+package mapping
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMapperMap(t *testing.T) {
+	textConfig := TagConfig{
+		Pattern:     "carbon.agents.#TEXT1.#TEXT2.#TEXT3",
+		Measurement: "#TEXT3",
+		Tags: []TagKeyValue{{Tagkey: "zone", Tagvalue: "#TEXT1"},
+			{Tagkey: "host", Tagvalue: "#TEXT2"}},
+		Field: "value",
+	}
+	regexConfig := TagConfig{
+		Pattern:     `^servers\.(?P<dc>[^.]+)\.(?P<host>[^.]+)\.(?P<metric>.+)$`,
+		Measurement: "#metric",
+		Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "#host"},
+			{Tagkey: "dc", Tagvalue: "dc-#dc"}},
+		Field: "value",
+	}
+	tests := []struct {
+		name       string
+		tagConfigs []TagConfig
+		sanitize   bool
+		metricName string
+		mtf        *MTF
+		err        error
+	}{
+		{
+			name:       "#TEXTn",
+			tagConfigs: []TagConfig{textConfig},
+			metricName: "carbon.agents.eu.host1.cpuUsage",
+			mtf: &MTF{Measurement: "cpuUsage", Field: "value",
+				Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "host1"},
+					{Tagkey: "zone", Tagvalue: "eu"}}},
+		},
+		{
+			name:       "regex #name expansion",
+			tagConfigs: []TagConfig{regexConfig},
+			metricName: "servers.ams1.web1.cpu.load",
+			mtf: &MTF{Measurement: "cpu.load", Field: "value",
+				Tags: []TagKeyValue{{Tagkey: "dc", Tagvalue: "dc-ams1"},
+					{Tagkey: "host", Tagvalue: "web1"}}},
+		},
+		{
+			name: "(?<name>) group",
+			tagConfigs: []TagConfig{{
+				Pattern:     `^stats\.(?<measurement>[^.]+)\.(?<field>[^.]+)$`,
+				Measurement: "#measurement",
+				Field:       "#field",
+			}},
+			metricName: "stats.requests.count",
+			mtf:        &MTF{Measurement: "requests", Field: "count", Tags: []TagKeyValue{}},
+		},
+		{
+			name: "unknown #name kept",
+			tagConfigs: []TagConfig{{
+				Pattern:     `^stats\.(?P<measurement>[^.]+)$`,
+				Measurement: "#measurement",
+				Field:       "#unknown",
+			}},
+			metricName: "stats.requests",
+			mtf:        &MTF{Measurement: "requests", Field: "#unknown", Tags: []TagKeyValue{}},
+		},
+		{
+			name:       "first match wins",
+			tagConfigs: []TagConfig{regexConfig, textConfig},
+			metricName: "carbon.agents.eu.host1.cpuUsage",
+			mtf: &MTF{Measurement: "cpuUsage", Field: "value",
+				Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: "host1"},
+					{Tagkey: "zone", Tagvalue: "eu"}}},
+		},
+		{
+			name: "sanitize",
+			tagConfigs: []TagConfig{{
+				Pattern:     `^(?P<measurement>.+)\.(?P<host>[^.]+)$`,
+				Measurement: "#measurement",
+				Tags:        []TagKeyValue{{Tagkey: "host name", Tagvalue: "#host"}},
+				Field:       "value",
+			}},
+			sanitize:   true,
+			metricName: "cpu load,idle.web=1",
+			mtf: &MTF{Measurement: "cpu_load_idle", Field: "value",
+				Tags: []TagKeyValue{{Tagkey: "host_name", Tagvalue: "web_1"}}},
+		},
+		{
+			name:       "no match",
+			tagConfigs: []TagConfig{textConfig, regexConfig},
+			metricName: "stats.requests.count",
+			err:        ErrNoMatch,
+		},
+		{
+			name: "empty measurement",
+			tagConfigs: []TagConfig{{
+				Pattern:     `^(?P<measurement>[a-z]*)\.cpu$`,
+				Measurement: "#measurement",
+				Field:       "value",
+			}},
+			metricName: ".cpu",
+			err:        ErrInvalidSeries,
+		},
+		{
+			name:       "empty field",
+			tagConfigs: []TagConfig{{Pattern: "carbon.#TEXT1"}},
+			metricName: "carbon.cpu",
+			err:        ErrInvalidSeries,
+		},
+	}
+
+	for _, test := range tests {
+		mapper, err := NewMapper(test.tagConfigs)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		mapper.Sanitize = test.sanitize
+		mtf, err := mapper.Map(test.metricName)
+		if err != test.err {
+			t.Errorf("%s: error %v, expected %v", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(mtf, test.mtf) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, mtf, test.mtf)
+		}
+	}
+}
+
+func TestNewMapperPatternError(t *testing.T) {
+	for _, pattern := range []string{`^(?P<host>[^.]+\.cpu$`, "carbon.(#TEXT1"} {
+		_, err := NewMapper([]TagConfig{{Pattern: pattern}})
+		var patternError *PatternError
+		if !errors.As(err, &patternError) || patternError.Pattern != pattern {
+			t.Errorf("%s: expected a *PatternError, got %v", pattern, err)
+		}
+	}
+}
+
+func TestTagConfigRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		regex   bool
+		re      string
+	}{
+		{pattern: "carbon.agents.#TEXT1.#TEXT2", re: "carbon.agents."},
+		{pattern: `^carbon\.(\w+)\.#TEXT1`, re: `^carbon\.(\w+)\.`},
+		{pattern: `^carbon\.(?P<host>\w+)$`, regex: true, re: `^carbon\.(?P<host>\w+)$`},
+		{pattern: `^carbon\.(?<host>\w+)$`, regex: true, re: `^carbon\.(?<host>\w+)$`},
+	}
+	for _, test := range tests {
+		tagConfig := TagConfig{Pattern: test.pattern}
+		re, err := tagConfig.Regexp()
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+			continue
+		}
+		if re.String() != test.re || tagConfig.IsRegex() != test.regex {
+			t.Errorf("%s: got %q regex %v, expected %q regex %v", test.pattern,
+				re.String(), tagConfig.IsRegex(), test.re, test.regex)
+		}
+	}
+}
+
+func TestCreateTSMKey(t *testing.T) {
+	tests := []struct {
+		mtf *MTF
+		key string
+	}{
+		{
+			mtf: &MTF{Measurement: "cpu", Field: "value"},
+			key: "cpu#!~#value",
+		},
+		{
+			mtf: &MTF{Measurement: "cpu", Field: "idle",
+				Tags: []TagKeyValue{{Tagkey: "zone", Tagvalue: "eu"},
+					{Tagkey: "host", Tagvalue: "web1"}}},
+			key: "cpu,host=web1,zone=eu#!~#idle",
+		},
+		{
+			mtf: &MTF{Measurement: "cpu load,total", Field: "value",
+				Tags: []TagKeyValue{{Tagkey: "host name", Tagvalue: "a=b,c d"}}},
+			key: `cpu\ load\,total,host\ name=a\=b\,c\ d#!~#value`,
+		},
+		{
+			mtf: &MTF{Measurement: "cpu", Field: "value",
+				Tags: []TagKeyValue{{Tagkey: "host", Tagvalue: ""},
+					{Tagkey: "dc", Tagvalue: "ams1"}}},
+			key: "cpu,dc=ams1#!~#value",
+		},
+	}
+	for _, test := range tests {
+		if key := CreateTSMKey(test.mtf); key != test.key {
+			t.Errorf("%+v: got %q, expected %q", test.mtf, key, test.key)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name      string
+		sanitized string
+	}{
+		{"cpu.load", "cpu.load"},
+		{`a,b c=d"e\f`, "a_b_c_d_e_f"},
+		{"tab\there\n", "tab_here_"},
+		{"bad\xffutf8", "bad_utf8"},
+		{"héllo", "héllo"},
+	}
+	for _, test := range tests {
+		if sanitized := SanitizeName(test.name); sanitized != test.sanitized {
+			t.Errorf("%q: got %q, expected %q", test.name, sanitized, test.sanitized)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package migration
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// AggregationReport is a CSV file with the aggregation method, xFilesFactor
+// and archives of every migrated whisper file, so continuous queries can
+// reproduce the Graphite rollups
+type AggregationReport struct {
+	f    *os.File
+	w    *csv.Writer
+	lock sync.Mutex
+}
+
+func NewAggregationReport(filename string) (*AggregationReport, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	report := &AggregationReport{f: f, w: csv.NewWriter(f)}
+	report.w.Write([]string{"whisper_file", "series_key", "aggregation_method",
+		"x_files_factor", "archives"})
+	return report, nil
+}
+
+// Add a whisper file to the report, archives are listed as
+// secondsPerPoint:points
+func (report *AggregationReport) Add(wspFile string, key string,
+	header whisper.Header) error {
+
+	archives := make([]string, len(header.Archives))
+	for i, archive := range header.Archives {
+		archives[i] = fmt.Sprintf("%d:%d", archive.SecondsPerPoint, archive.Points)
+	}
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	return report.w.Write([]string{wspFile, key,
+		header.Metadata.AggregationMethod.String(),
+		fmt.Sprint(header.Metadata.XFilesFactor), strings.Join(archives, " ")})
+}
+
+func (report *AggregationReport) Close() error {
+	report.w.Flush()
+	if err := report.w.Error(); err != nil {
+		report.f.Close()
+		return err
+	}
+	return report.f.Close()
+}
@@ -0,0 +1,156 @@
+package migration
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+	"github.com/uttamgandhi/graphite-influx/mapping"
+)
+
+// Policies for whisper files which map to the same series key
+const (
+	// Stop the migration listing the colliding files
+	CollisionFail = "fail"
+	// Merge the points of the files into one series, points with the same
+	// timestamp are combined with the merge rule
+	CollisionMerge = "merge"
+	// Add a tag with the metric name of the file to tell the series apart
+	CollisionTag = "tag"
+)
+
+// Rules to combine points of merged series with the same timestamp, first and
+// last are by whisper file name
+var mergeRules = map[string]func(values []float64) float64{
+	"first": func(values []float64) float64 { return values[0] },
+	"last":  func(values []float64) float64 { return values[len(values)-1] },
+	"max": func(values []float64) float64 {
+		max := values[0]
+		for _, value := range values[1:] {
+			if value > max {
+				max = value
+			}
+		}
+		return max
+	},
+	"min": func(values []float64) float64 {
+		min := values[0]
+		for _, value := range values[1:] {
+			if value < min {
+				min = value
+			}
+		}
+		return min
+	},
+	"sum": func(values []float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum = sum + value
+		}
+		return sum
+	},
+	"average": func(values []float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum = sum + value
+		}
+		return sum / float64(len(values))
+	},
+}
+
+// Find the whisper files mapped to the same series key and apply the
+// -on-collision policy. With the merge policy the colliding files are
+// remembered so their series are merged before they are written
+func (migrationData *MigrationData) ResolveCollisions() error {
+	keyFiles := make(map[string][]string)
+	for _, wspFile := range migrationData.wspFiles {
+		key := mapping.CreateTSMKey(migrationData.mtfs[wspFile])
+		keyFiles[key] = append(keyFiles[key], wspFile)
+	}
+
+	var keys []string
+	for key, files := range keyFiles {
+		if len(files) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	migrationData.collisions = make(map[string]bool)
+	for _, key := range keys {
+		files := keyFiles[key]
+		log.Println("Series key", key, "collides for", strings.Join(files, ", "))
+		switch migrationData.onCollision {
+		case CollisionFail:
+		case CollisionMerge:
+			for _, wspFile := range files {
+				migrationData.collisions[wspFile] = true
+			}
+		case CollisionTag:
+			for _, wspFile := range files {
+				migrationData.mtfs[wspFile] = migrationData.CollisionMTF(
+					migrationData.mtfs[wspFile], wspFile)
+			}
+		}
+	}
+	if migrationData.onCollision == CollisionFail {
+		return fmt.Errorf("%d series keys collide", len(keys))
+	}
+	return nil
+}
+
+// Tag the series of a colliding whisper file with its metric name
+func (migrationData *MigrationData) CollisionMTF(mtf *mapping.MTF,
+	wspFile string) *mapping.MTF {
+
+	tagged := *mtf
+	tagged.Tags = append(append([]mapping.TagKeyValue{}, mtf.Tags...),
+		mapping.TagKeyValue{Tagkey: migrationData.collisionTag,
+			Tagvalue: migrationData.MetricName(wspFile)})
+	return migrationData.NormalizeMTF(&tagged)
+}
+
+// Merge series with the same key into one, values with the same timestamp
+// are combined with the merge rule
+func MergeTsmPoints(tsmPoints []TsmPoint, rule string) TsmPoint {
+	sort.Sort(byWspFile(tsmPoints))
+	timestampValues := make(map[int64][]float64)
+	for _, tsmPoint := range tsmPoints {
+		for _, value := range tsmPoint.values {
+			floatValue, _ := value.Value().(float64)
+			timestampValues[value.UnixNano()] = append(
+				timestampValues[value.UnixNano()], floatValue)
+		}
+	}
+	timestamps := make([]int64, 0, len(timestampValues))
+	for timestamp := range timestampValues {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Sort(int64s(timestamps))
+
+	merged := tsmPoints[0]
+	merged.values = make([]tsm1.Value, len(timestamps))
+	for i, timestamp := range timestamps {
+		merged.values[i] = tsm1.NewValue(time.Unix(0, timestamp),
+			mergeRules[rule](timestampValues[timestamp]))
+	}
+	return merged
+}
+
+type byWspFile []TsmPoint
+
+func (a byWspFile) Len() int           { return len(a) }
+func (a byWspFile) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byWspFile) Less(i, j int) bool { return a[i].wspFile < a[j].wspFile }
+
+type int64s []int64
+
+func (a int64s) Len() int           { return len(a) }
+func (a int64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64s) Less(i, j int) bool { return a[i] < a[j] }
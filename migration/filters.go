@@ -0,0 +1,222 @@
+package migration
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uttamgandhi/graphite-influx/mapping"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// How the age of a whisper file is found for -skip-stale
+const (
+	// Modification time of the file
+	StaleByMtime = "mtime"
+	// Timestamp of the newest point in any archive
+	StaleByDatapoint = "datapoint"
+)
+
+// A filter on dotted metric names. Patterns in slashes, e.g. /^servers\./,
+// are regular expressions, other patterns are globs matched part by part
+// against the leading parts of the name like the filters of graphite
+// templates, e.g. servers.*.cpu matches servers.host1.cpu.user
+type MetricFilter struct {
+	pattern string
+	re      *regexp.Regexp
+	parts   []string
+}
+
+// A list of metric filters, the flag can be given more than once
+type MetricFilters []*MetricFilter
+
+func (filters *MetricFilters) String() string {
+	patterns := make([]string, len(*filters))
+	for i, filter := range *filters {
+		patterns[i] = filter.pattern
+	}
+	return strings.Join(patterns, ",")
+}
+
+func (filters *MetricFilters) Set(pattern string) error {
+	filter, err := NewMetricFilter(pattern)
+	if err != nil {
+		return err
+	}
+	*filters = append(*filters, filter)
+	return nil
+}
+
+func NewMetricFilter(pattern string) (*MetricFilter, error) {
+	filter := &MetricFilter{pattern: pattern}
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") &&
+		strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric filter %q: %v", pattern, err)
+		}
+		filter.re = re
+		return filter, nil
+	}
+	filter.parts = strings.Split(pattern, ".")
+	for _, part := range filter.parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("invalid metric filter %q: %v", pattern, err)
+		}
+	}
+	return filter, nil
+}
+
+func (filter *MetricFilter) Matches(metricName string) bool {
+	if filter.re != nil {
+		return filter.re.MatchString(metricName)
+	}
+	nameParts := strings.Split(metricName, ".")
+	if len(filter.parts) > len(nameParts) {
+		return false
+	}
+	for i, part := range filter.parts {
+		if matched, _ := path.Match(part, nameParts[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// A metric is migrated if it matches any include filter, or there are none,
+// and matches no exclude filter
+func (migrationData *MigrationData) IncludeMetric(metricName string) bool {
+	for _, filter := range migrationData.exclude {
+		if filter.Matches(metricName) {
+			return false
+		}
+	}
+	if len(migrationData.include) == 0 {
+		return true
+	}
+	for _, filter := range migrationData.include {
+		if filter.Matches(metricName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse a duration which can also be given in days or weeks, e.g. 30d or 2w
+func ParseAge(age string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if !strings.HasSuffix(age, suffix) {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSuffix(age, suffix), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", age)
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+	return time.ParseDuration(age)
+}
+
+// Check whether a whisper file was last updated before now minus the
+// -skip-stale age
+func (migrationData *MigrationData) IsStale(wspFile string, info os.FileInfo,
+	now time.Time) (bool, error) {
+
+	if migrationData.skipStale <= 0 {
+		return false, nil
+	}
+	threshold := now.Add(-migrationData.skipStale)
+	if migrationData.staleBy == StaleByMtime {
+		return info.ModTime().Before(threshold), nil
+	}
+	newest, err := NewestTimestamp(wspFile, now)
+	if err != nil {
+		return false, err
+	}
+	return newest.Before(threshold), nil
+}
+
+// Get the timestamp of the newest valid point of a whisper file, the zero
+// time if it has none. Every update is written to the archive with the
+// highest resolution covering it, so the archives are read from the highest
+// resolution until one has a point. For a file updated within the retention
+// of its first archive only that archive is read
+func NewestTimestamp(wspFile string, now time.Time) (time.Time, error) {
+	w, err := whisper.Open(wspFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer w.Close()
+
+	for i, archive := range w.Header.Archives {
+		points, err := w.DumpArchive(i)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("read archive %d: %v", i, err)
+		}
+		until := uint32(now.Unix())
+		points, _ = mapping.ValidPoints(points, archive.SecondsPerPoint,
+			until-archive.Retention()+1, until)
+		var newest uint32
+		for _, point := range points {
+			if point.Timestamp > newest {
+				newest = point.Timestamp
+			}
+		}
+		if newest > 0 {
+			return time.Unix(int64(newest), 0), nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// Walk a directory for whisper files. Symlinks are followed if
+// -symlinks is set, directories already walked are skipped so symlink
+// loops end
+func (migrationData *MigrationData) walkWhisperFiles(dir string,
+	visited map[string]bool, walkFn func(path string, info os.FileInfo)) error {
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if visited[realDir] {
+		return nil
+	}
+	visited[realDir] = true
+
+	//Walk the resolved directory, the paths are reported below dir so metric
+	//names follow the symlinks
+	return filepath.Walk(realDir, func(path string, info os.FileInfo, err error) error {
+		if rel, relErr := filepath.Rel(realDir, path); relErr == nil {
+			path = filepath.Join(dir, rel)
+		}
+		if err != nil {
+			log.Println("Error in reading", path, err)
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !migrationData.symlinks {
+				log.Println("Skipping symlink", path)
+				return nil
+			}
+			if info, err = os.Stat(path); err != nil {
+				log.Println("Error in following symlink", path, err)
+				return nil
+			}
+			if info.IsDir() {
+				return migrationData.walkWhisperFiles(path, visited, walkFn)
+			}
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), mapping.WhisperExtension) {
+			walkFn(path, info)
+		}
+		return nil
+	})
+}
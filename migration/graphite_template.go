@@ -0,0 +1,344 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/uttamgandhi/graphite-influx/mapping"
+)
+
+// Templates in the same syntax as InfluxDB's graphite input, e.g.
+//
+//	separator = "_"
+//	tags = ["region=us-east"]
+//	templates = [
+//	  "servers.* .host.measurement.field",
+//	  "stats.* .measurement.field region=us-west",
+//	  "measurement*",
+//	]
+//
+// The keys can be at the top level of the file or inside a [[graphite]]
+// section, so the influxdb.conf section can be used as is. Templates are
+// validated and applied like the graphite input of InfluxDB 0.10
+type GraphiteConfig struct {
+	Separator string   `toml:"separator"`
+	Tags      []string `toml:"tags"`
+	Templates []string `toml:"templates"`
+}
+
+type graphiteConfigFile struct {
+	GraphiteConfig
+	Graphite []GraphiteConfig `toml:"graphite"`
+}
+
+const (
+	defaultSeparator = "."
+	defaultTemplate  = "measurement*"
+	defaultField     = "value"
+)
+
+type GraphiteTemplate struct {
+	line        string
+	parts       []string
+	defaultTags map[string]string
+	separator   string
+}
+
+// GraphiteTemplates finds the template of a metric name in a tree of the
+// template filters, like the graphite input's template matcher
+type GraphiteTemplates struct {
+	root            *templateNode
+	defaultTemplate *GraphiteTemplate
+	tags            map[string]string
+}
+
+// A node of the filter tree, the children are sorted by value with the
+// wildcard "*" last
+type templateNode struct {
+	value    string
+	children templateNodes
+	template *GraphiteTemplate
+}
+
+// Read the graphite template config file
+func ReadGraphiteTemplates(filename string) (*GraphiteTemplates, error) {
+	var configFile graphiteConfigFile
+	if _, err := toml.DecodeFile(filename, &configFile); err != nil {
+		return nil, err
+	}
+	config := configFile.GraphiteConfig
+	for _, graphiteConfig := range configFile.Graphite {
+		if len(graphiteConfig.Templates) > 0 {
+			config = graphiteConfig
+			break
+		}
+	}
+	return NewGraphiteTemplates(config)
+}
+
+// Validate and parse the templates of a graphite config
+func NewGraphiteTemplates(config GraphiteConfig) (*GraphiteTemplates, error) {
+	separator := config.Separator
+	if separator == "" {
+		separator = defaultSeparator
+	}
+	tags, err := parseTemplateTags(config.Tags)
+	if err != nil {
+		return nil, err
+	}
+	graphiteTemplates := &GraphiteTemplates{root: &templateNode{}, tags: tags}
+	graphiteTemplates.defaultTemplate, _ = newGraphiteTemplate(defaultTemplate,
+		nil, separator)
+	graphiteTemplates.defaultTemplate.line = defaultTemplate
+
+	filters := make(map[string]bool)
+	for i, line := range config.Templates {
+		var filter, template, tagStr string
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			return nil, fmt.Errorf("missing template at position: %d", i)
+		case 1:
+			template = fields[0]
+		case 2:
+			if strings.Contains(fields[1], "=") {
+				template, tagStr = fields[0], fields[1]
+			} else {
+				filter, template = fields[0], fields[1]
+			}
+		case 3:
+			filter, template, tagStr = fields[0], fields[1], fields[2]
+		default:
+			return nil, fmt.Errorf("invalid template format: %q", line)
+		}
+
+		if err := validateTemplate(template); err != nil {
+			return nil, err
+		}
+		if filters[filter] {
+			return nil, fmt.Errorf("duplicate filter %q found at position: %d",
+				filter, i)
+		}
+		filters[filter] = true
+		if err := validateFilter(filter); err != nil {
+			return nil, err
+		}
+		var tagStrs []string
+		if tagStr != "" {
+			tagStrs = strings.Split(tagStr, ",")
+		}
+		defaultTags, err := parseTemplateTags(tagStrs)
+		if err != nil {
+			return nil, err
+		}
+
+		graphiteTemplate, err := newGraphiteTemplate(template, defaultTags,
+			separator)
+		if err != nil {
+			return nil, err
+		}
+		graphiteTemplate.line = line
+		if filter == "" {
+			graphiteTemplates.defaultTemplate = graphiteTemplate
+		} else {
+			graphiteTemplates.root.insert(strings.Split(filter, "."),
+				graphiteTemplate)
+		}
+	}
+	return graphiteTemplates, nil
+}
+
+// A template needs a measurement or measurement* part
+func validateTemplate(template string) error {
+	for _, part := range strings.Split(template, ".") {
+		if part == "measurement" || part == "measurement*" {
+			return nil
+		}
+	}
+	return fmt.Errorf("no measurement in template %q", template)
+}
+
+// Filter parts are literal or the wildcard "*"
+func validateFilter(filter string) error {
+	if filter == "" {
+		return nil
+	}
+	for _, part := range strings.Split(filter, ".") {
+		if part == "" {
+			return fmt.Errorf("filter contains blank section: %q", filter)
+		}
+		if strings.Contains(part, "*") && part != "*" {
+			return fmt.Errorf("invalid filter wildcard section: %q", filter)
+		}
+	}
+	return nil
+}
+
+// Parse tags in the form region=us-west
+func parseTemplateTags(tagStrs []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, kv := range tagStrs {
+		parts := strings.Split(kv, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid template tags: %q", kv)
+		}
+		tags[parts[0]] = parts[1]
+	}
+	return tags, nil
+}
+
+func newGraphiteTemplate(template string, defaultTags map[string]string,
+	separator string) (*GraphiteTemplate, error) {
+
+	parts := strings.Split(template, ".")
+	hasMeasurement := false
+	for _, part := range parts {
+		if strings.HasPrefix(part, "measurement") {
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return nil, fmt.Errorf("no measurement specified for template %q", template)
+	}
+	return &GraphiteTemplate{parts: parts, defaultTags: defaultTags,
+		separator: separator}, nil
+}
+
+// Get measurement, tags and field for a dotted graphite metric name, the
+// tags are in no particular order until normalized with mapping.NormalizeMTF
+func (graphiteTemplates *GraphiteTemplates) GetMTF(metricName string) *mapping.MTF {
+	mtf, _ := graphiteTemplates.MatchMTF(metricName)
+	return mtf
+}
+
+// Get measurement, tags and field for a metric name and the template line
+// which was applied. The MTF is nil if the template can't be applied, like
+// the graphite input drops such metrics
+func (graphiteTemplates *GraphiteTemplates) MatchMTF(metricName string) (*mapping.MTF,
+	string) {
+
+	graphiteTemplate := graphiteTemplates.Match(metricName)
+	measurement, tags, field, err := graphiteTemplate.Apply(metricName)
+	if err != nil {
+		return nil, graphiteTemplate.line
+	}
+	if measurement == "" {
+		measurement = metricName
+	}
+	if field == "" {
+		field = defaultField
+	}
+	for key, value := range graphiteTemplates.tags {
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+	}
+
+	mtf := &mapping.MTF{Measurement: measurement, Field: field}
+	for key, value := range tags {
+		mtf.Tags = append(mtf.Tags, mapping.TagKeyValue{Tagkey: key, Tagvalue: value})
+	}
+	return mtf, graphiteTemplate.line
+}
+
+// Get the template of the filter tree for a metric name, or the default
+// template
+func (graphiteTemplates *GraphiteTemplates) Match(metricName string) *GraphiteTemplate {
+	if graphiteTemplate := graphiteTemplates.root.search(
+		strings.Split(metricName, ".")); graphiteTemplate != nil {
+		return graphiteTemplate
+	}
+	return graphiteTemplates.defaultTemplate
+}
+
+// Apply the template to a metric name. Measurement parts are joined with the
+// separator, a repeated tag takes the last part and field can be used once
+func (graphiteTemplate *GraphiteTemplate) Apply(metricName string) (string,
+	map[string]string, string, error) {
+
+	nameParts := strings.Split(metricName, ".")
+	var measurement []string
+	var field string
+	tags := make(map[string]string)
+	for key, value := range graphiteTemplate.defaultTags {
+		tags[key] = value
+	}
+
+	for i, part := range graphiteTemplate.parts {
+		if i >= len(nameParts) {
+			break
+		}
+		if part == "measurement" {
+			measurement = append(measurement, nameParts[i])
+		} else if part == "field" {
+			if field != "" {
+				return "", nil, "", fmt.Errorf(
+					"'field' can only be used once in each template: %q", metricName)
+			}
+			field = nameParts[i]
+		} else if part == "measurement*" {
+			measurement = append(measurement, nameParts[i:]...)
+			break
+		} else if part != "" {
+			tags[part] = nameParts[i]
+		}
+	}
+	return strings.Join(measurement, graphiteTemplate.separator), tags, field, nil
+}
+
+func (node *templateNode) insert(values []string, graphiteTemplate *GraphiteTemplate) {
+	if len(values) == 0 {
+		node.template = graphiteTemplate
+		return
+	}
+	for _, child := range node.children {
+		if child.value == values[0] {
+			child.insert(values[1:], graphiteTemplate)
+			return
+		}
+	}
+	child := &templateNode{value: values[0]}
+	node.children = append(node.children, child)
+	sort.Sort(node.children)
+	child.insert(values[1:], graphiteTemplate)
+}
+
+// Follow the exact child of each name part, else the wildcard child. There is
+// no backtracking, a branch without a template gives nil
+func (node *templateNode) search(nameParts []string) *GraphiteTemplate {
+	if len(nameParts) == 0 || len(node.children) == 0 {
+		return node.template
+	}
+
+	//The wildcard is sorted last, leave it out of the binary search
+	length := len(node.children)
+	wildcard := node.children[length-1].value == "*"
+	if wildcard {
+		length--
+	}
+	i := sort.Search(length, func(i int) bool {
+		return node.children[i].value >= nameParts[0]
+	})
+	if i < len(node.children) && node.children[i].value == nameParts[0] {
+		return node.children[i].search(nameParts[1:])
+	}
+	if wildcard {
+		return node.children[len(node.children)-1].search(nameParts[1:])
+	}
+	return node.template
+}
+
+type templateNodes []*templateNode
+
+// Sort by value, the wildcard "*" is never less than a literal
+func (nodes templateNodes) Less(i, j int) bool {
+	if nodes[i].value == "*" || nodes[j].value == "*" {
+		return nodes[j].value == "*" && nodes[i].value != "*"
+	}
+	return nodes[i].value < nodes[j].value
+}
+
+func (nodes templateNodes) Swap(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] }
+func (nodes templateNodes) Len() int      { return len(nodes) }
@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/influxdb/influxdb/models"
+	"github.com/influxdb/influxdb/services/graphite"
+	"github.com/uttamgandhi/graphite-influx/mapping"
+)
+
+// Templates are matched and applied like the graphite parser of InfluxDB 0.10
+func TestGraphiteTemplatesMatchParser(t *testing.T) {
+	configs := []GraphiteConfig{
+		{},
+		{
+			Separator: "_",
+			Tags:      []string{"region=us-east", "dc=1"},
+			Templates: []string{
+				"servers.localhost .host.measurement*",
+				"servers.localhost.cpu .host.measurement.field",
+				"servers.* .wrong.measurement*",
+				"servers.*.cpu .host.measurement.field",
+				"stats.* .measurement.field.field",
+				"tags.* .measurement.host.host",
+				"regions.* .measurement.measurement.region region=us-west,zone=1a",
+				"*.localhost .measurement.host",
+				"hosts.web1.cpu .host.measurement",
+				"hosts.* .measurement.host",
+				"*.*.disk measurement..field",
+				"measurement.measurement.field",
+			},
+		},
+	}
+	metricNames := []string{
+		"a", "a.b", "a.b.c.d",
+		"servers", "servers.localhost", "servers.localhost.cpu",
+		"servers.localhost.mem", "servers.localhost.cpu.idle",
+		//No backtracking from hosts.web1.cpu to hosts.*
+		"hosts.web1.mem", "hosts.web1.cpu", "hosts.web2.cpu",
+		"servers.web1", "servers.web1.cpu.idle", "servers.web1.mem",
+		"stats.counters.hits.count", "stats.counters",
+		"tags.x.web1.web2",
+		"regions.cpu.idle.eu", "regions.cpu",
+		"web.localhost.load", "servers.web1.disk.used",
+		"*.localhost", "web.*.disk",
+	}
+
+	for i, config := range configs {
+		graphiteTemplates, err := NewGraphiteTemplates(config)
+		if err != nil {
+			t.Fatalf("config %d: %v", i, err)
+		}
+		separator := config.Separator
+		if separator == "" {
+			separator = graphite.DefaultSeparator
+		}
+		tags := models.Tags{}
+		for key, value := range graphiteTemplates.tags {
+			tags[key] = value
+		}
+		parser, err := graphite.NewParserWithOptions(graphite.Options{
+			Separator: separator, Templates: config.Templates, DefaultTags: tags})
+		if err != nil {
+			t.Fatalf("config %d: %v", i, err)
+		}
+
+		for _, metricName := range metricNames {
+			var expected *mapping.MTF
+			//ApplyTemplate panics on a template error with default tags
+			point, err := parser.Parse(metricName + " 1 60")
+			if err == nil {
+				expected = &mapping.MTF{Measurement: point.Name()}
+				for field := range point.Fields() {
+					expected.Field = field
+				}
+				for key, value := range point.Tags() {
+					expected.Tags = append(expected.Tags,
+						mapping.TagKeyValue{Tagkey: key, Tagvalue: value})
+				}
+				expected = mapping.NormalizeMTF(expected, false)
+			}
+
+			mtf := mapping.NormalizeMTF(graphiteTemplates.GetMTF(metricName), false)
+			if !reflect.DeepEqual(mtf, expected) {
+				t.Errorf("config %d, %s: got %+v, parser %+v", i, metricName,
+					mtf, expected)
+			}
+		}
+	}
+}
+
+// Configs are rejected like the graphite input's config validation
+func TestGraphiteTemplatesInvalid(t *testing.T) {
+	configs := []GraphiteConfig{
+		{Templates: []string{"servers.* .host.field"}},
+		{Templates: []string{"servers.* measurement*", "servers.* .measurement"}},
+		{Templates: []string{"measurement*", ".host.measurement*"}},
+		{Templates: []string{"serv*.* .host.measurement*"}},
+		{Templates: []string{"servers..cpu .host.measurement*"}},
+		{Templates: []string{"servers.* .host.measurement* region"}},
+		{Templates: []string{"measurement* region="}},
+		{Templates: []string{"servers.* .host.measurement* region=us-west extra"}},
+		{Templates: []string{"  "}},
+		{Tags: []string{"region"}},
+		{Tags: []string{"=us-west"}},
+	}
+	for i, config := range configs {
+		if _, err := NewGraphiteTemplates(config); err == nil {
+			t.Errorf("config %d: expected an error for %+v", i, config)
+		}
+		graphiteConfig := graphite.Config{Templates: config.Templates,
+			Tags: config.Tags}
+		if err := graphiteConfig.Validate(); err == nil {
+			t.Errorf("config %d: parser config accepts %+v", i, config)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package migration
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/uttamgandhi/graphite-influx/config"
+)
+
+// HTTPSink writes the series of a shard through the InfluxDB HTTP API as
+// batches of line protocol, so no access to the data directory of InfluxDB is
+// needed. The batches are posted to /write directly as the client does not
+// expose the status code of a failed write
+type HTTPSink struct {
+	influxConfig    config.InfluxConfig
+	httpClient      *http.Client
+	database        string
+	retentionPolicy string
+	batchSize       int
+	retries         int
+	backoff         time.Duration
+	lines           []string
+	written         int64
+}
+
+// Create a HTTPSink, a batch failing with a transport error or a 5xx status
+// is retried up to retries times waiting backoff before the first retry and
+// doubling it after every retry. Other errors are not retried
+func NewHTTPSink(influxConfig config.InfluxConfig, httpClient *http.Client,
+	database string, retentionPolicy string, batchSize int, retries int,
+	backoff time.Duration) *HTTPSink {
+
+	return &HTTPSink{influxConfig: influxConfig, httpClient: httpClient,
+		database: database, retentionPolicy: retentionPolicy,
+		batchSize: batchSize, retries: retries, backoff: backoff}
+}
+
+func (sink *HTTPSink) Open() error {
+	sink.lines = nil
+	sink.written = 0
+	return nil
+}
+
+// Add the points of a series to the batch, full batches of batchSize points
+// are written
+func (sink *HTTPSink) WriteSeries(tsmPoint TsmPoint) error {
+	lines, err := LineProtocol(tsmPoint)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		sink.lines = append(sink.lines, line)
+		if len(sink.lines) < sink.batchSize {
+			continue
+		}
+		if err := sink.write(sink.lines); err != nil {
+			return err
+		}
+		sink.written = sink.written + int64(len(sink.lines))
+		sink.lines = nil
+	}
+	return nil
+}
+
+// Write the last batch
+func (sink *HTTPSink) Close() error {
+	if len(sink.lines) == 0 {
+		return nil
+	}
+	if err := sink.write(sink.lines); err != nil {
+		return err
+	}
+	sink.written = sink.written + int64(len(sink.lines))
+	sink.lines = nil
+	return nil
+}
+
+// Number of points written by the batches InfluxDB accepted
+func (sink *HTTPSink) Written() int64 {
+	return sink.written
+}
+
+// Error of a write which may succeed if retried
+type retryableError struct {
+	err error
+}
+
+func (err retryableError) Error() string {
+	return err.err.Error()
+}
+
+// Write a batch, retrying with exponential backoff
+func (sink *HTTPSink) write(lines []string) error {
+	backoff := sink.backoff
+	var err error
+	for attempt := 0; attempt <= sink.retries; attempt++ {
+		if attempt > 0 {
+			log.Println("Write failed, retrying in", backoff, err)
+			time.Sleep(backoff)
+			backoff = backoff * 2
+		}
+		if err = sink.post(lines); err == nil {
+			return nil
+		}
+		if _, ok := err.(retryableError); !ok {
+			break
+		}
+	}
+	return fmt.Errorf("write batch of %d points: %v", len(lines), err)
+}
+
+// Post a batch of line protocol with nanosecond timestamps to /write
+func (sink *HTTPSink) post(lines []string) error {
+	u, err := url.Parse(sink.influxConfig.Addr)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+	params := url.Values{}
+	params.Set("db", sink.database)
+	params.Set("rp", sink.retentionPolicy)
+	params.Set("precision", "ns")
+	u.RawQuery = params.Encode()
+
+	body := strings.Join(lines, "\n") + "\n"
+	req, err := http.NewRequest("POST", u.String(), bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", sink.influxConfig.UserAgent)
+	if sink.influxConfig.Username != "" {
+		req.SetBasicAuth(sink.influxConfig.Username, sink.influxConfig.Password)
+	}
+	resp, err := sink.httpClient.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	err = fmt.Errorf("%v: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	if resp.StatusCode >= 500 {
+		return retryableError{err}
+	}
+	return err
+}
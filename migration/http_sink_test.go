@@ -0,0 +1,106 @@
+package migration
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+	"github.com/uttamgandhi/graphite-influx/config"
+	"github.com/uttamgandhi/graphite-influx/mapping"
+)
+
+func TestHTTPSinkRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		fail     bool
+	}{
+		{name: "success", statuses: []int{204}, requests: 1},
+		{name: "5xx retried", statuses: []int{500, 503, 204}, requests: 3},
+		{name: "5xx retries exhausted", statuses: []int{500, 500, 500, 500},
+			requests: 4, fail: true},
+		{name: "4xx not retried", statuses: []int{400, 204}, requests: 1,
+			fail: true},
+		{name: "404 not retried", statuses: []int{404, 204}, requests: 1,
+			fail: true},
+	}
+	for _, test := range tests {
+		var requests int
+		var body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+
+			status := test.statuses[requests]
+			requests = requests + 1
+			if r.URL.Path != "/write" || r.URL.Query().Get("db") != "graphite" ||
+				r.URL.Query().Get("rp") != "default" {
+				t.Errorf("%s: unexpected request %v", test.name, r.URL)
+			}
+			raw, _ := ioutil.ReadAll(r.Body)
+			body = string(raw)
+			w.WriteHeader(status)
+		}))
+
+		sink := NewHTTPSink(config.InfluxConfig{Addr: server.URL},
+			server.Client(), "graphite", "default", 10, 3, time.Millisecond)
+		if err := sink.Open(); err != nil {
+			t.Fatal(err)
+		}
+		err := sink.WriteSeries(TsmPoint{key: "cpu#value",
+			mtf: &mapping.MTF{Measurement: "cpu", Field: "value",
+				Tags: []mapping.TagKeyValue{{Tagkey: "host", Tagvalue: "a"}}},
+			values: []tsm1.Value{tsm1.NewValue(time.Unix(60, 0), 1.5)}})
+		if err == nil {
+			err = sink.Close()
+		}
+		server.Close()
+
+		if test.fail && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if !test.fail && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if requests != test.requests {
+			t.Errorf("%s: %d requests, expected %d", test.name, requests,
+				test.requests)
+		}
+		if !strings.HasPrefix(body, "cpu,host=a value=1.5 60000000000") {
+			t.Errorf("%s: unexpected body %q", test.name, body)
+		}
+	}
+}
+
+// Fails the first failures requests without a response
+type failingTransport struct {
+	failures int
+	requests int
+}
+
+func (transport *failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	transport.requests = transport.requests + 1
+	if transport.requests <= transport.failures {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusNoContent, Status: "204",
+		Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+func TestHTTPSinkTransportErrorRetried(t *testing.T) {
+	transport := &failingTransport{failures: 2}
+	sink := NewHTTPSink(config.InfluxConfig{Addr: "http://influxdb:8086"},
+		&http.Client{Transport: transport}, "graphite", "default", 1, 3,
+		time.Millisecond)
+	if err := sink.write([]string{"cpu value=1 60000000000"}); err != nil {
+		t.Fatal(err)
+	}
+	if transport.requests != 3 {
+		t.Errorf("%d requests, expected 3", transport.requests)
+	}
+}
@@ -0,0 +1,164 @@
+package migration
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Journal is an append only checkpoint file of the migration. Once the series
+// of a whisper file are durably written to a shard, an entry with the last
+// timestamp written is appended for the file, and once the output of a shard
+// is written an entry marking the shard as done. A -resume run skips the
+// shards marked as done and the whisper files already written to the other
+// shards. Only sinks keeping what they wrote when a shard is written again
+// record whisper files, the other shards are migrated again from scratch
+type Journal struct {
+	f          *os.File
+	database   string
+	lock       sync.Mutex
+	doneShards map[string]bool
+	doneFiles  map[string]map[string]bool
+}
+
+// One line of the journal file, a shard is identified by database, retention
+// policy and shard group id
+type JournalEntry struct {
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retentionPolicy"`
+	Shard           string `json:"shard"`
+	WspFile         string `json:"wspFile,omitempty"`
+	LastTimestamp   int64  `json:"lastTimestamp,omitempty"`
+	Done            bool   `json:"done,omitempty"`
+}
+
+// Open the journal of the migration to a database, when resuming the existing
+// entries are loaded otherwise the journal is truncated
+func OpenJournal(filename string, database string, resume bool) (*Journal, error) {
+	journal := &Journal{database: database, doneShards: make(map[string]bool),
+		doneFiles: make(map[string]map[string]bool)}
+
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(filename, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	journal.f = f
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry JournalEntry
+		//A crash can leave the last line incomplete, it is ignored
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		journal.apply(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return journal, nil
+}
+
+func (journal *Journal) apply(entry JournalEntry) {
+	if entry.Done {
+		journal.doneShards[entry.key()] = true
+	}
+	if entry.WspFile != "" {
+		if journal.doneFiles[entry.key()] == nil {
+			journal.doneFiles[entry.key()] = make(map[string]bool)
+		}
+		journal.doneFiles[entry.key()][entry.WspFile] = true
+	}
+}
+
+func (entry JournalEntry) key() string {
+	return entry.Database + "/" + entry.RetentionPolicy + "/" + entry.Shard
+}
+
+func (journal *Journal) entry(shard ShardInfo) JournalEntry {
+	return JournalEntry{Database: journal.database,
+		RetentionPolicy: shard.retentionPolicy, Shard: shard.id.String()}
+}
+
+// Check if the output of a shard was completely written
+func (journal *Journal) ShardDone(shard ShardInfo) bool {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	return journal.doneShards[journal.entry(shard).key()]
+}
+
+// Check if the series of a whisper file were written to a shard
+func (journal *Journal) FileDone(shard ShardInfo, wspFile string) bool {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	return journal.doneFiles[journal.entry(shard).key()][wspFile]
+}
+
+// Journal entry of a whisper file with the last timestamp of its series
+// written to a shard
+func (journal *Journal) FileEntry(shard ShardInfo, wspFile string,
+	tsmPoints []TsmPoint) JournalEntry {
+
+	entry := journal.entry(shard)
+	entry.WspFile = wspFile
+	for _, tsmPoint := range tsmPoints {
+		for _, value := range tsmPoint.values {
+			if ts := value.UnixNano() / int64(time.Second); ts > entry.LastTimestamp {
+				entry.LastTimestamp = ts
+			}
+		}
+	}
+	return entry
+}
+
+// Record the whisper files written to a shard, the journal is synced before
+// returning
+func (journal *Journal) RecordFiles(entries []JournalEntry) error {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+	return journal.record(entries)
+}
+
+// Mark the shard as done, the journal is synced before returning
+func (journal *Journal) RecordShard(shard ShardInfo) error {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+
+	entry := journal.entry(shard)
+	entry.Done = true
+	return journal.record([]JournalEntry{entry})
+}
+
+func (journal *Journal) record(entries []JournalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	w := bufio.NewWriter(journal.f)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := journal.f.Sync(); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		journal.apply(entry)
+	}
+	return nil
+}
+
+func (journal *Journal) Close() error {
+	return journal.f.Close()
+}
@@ -0,0 +1,129 @@
+package migration
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+)
+
+// Writes every series durably, Close fails if fail is set
+type recordingSink struct {
+	wspFiles []string
+	written  int64
+	fail     bool
+}
+
+func (sink *recordingSink) Open() error {
+	return nil
+}
+
+func (sink *recordingSink) WriteSeries(tsmPoint TsmPoint) error {
+	sink.wspFiles = append(sink.wspFiles, tsmPoint.wspFile)
+	sink.written = sink.written + int64(len(tsmPoint.values))
+	return nil
+}
+
+func (sink *recordingSink) Close() error {
+	if sink.fail {
+		return errors.New("crashed")
+	}
+	return nil
+}
+
+func (sink *recordingSink) Written() int64 {
+	return sink.written
+}
+
+func TestJournalResumeFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphite-influx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "migration.journal")
+
+	shard := ShardInfo{id: "3", retentionPolicy: "default"}
+	files := [][]TsmPoint{
+		{{key: "a#value", wspFile: "a.wsp", values: []tsm1.Value{
+			tsm1.NewValue(time.Unix(60, 0), 1.0), tsm1.NewValue(time.Unix(120, 0), 2.0)}}},
+		{{key: "b#value", wspFile: "b.wsp", values: []tsm1.Value{
+			tsm1.NewValue(time.Unix(180, 0), 3.0)}}},
+	}
+	writeShard := func(resume bool, sink *recordingSink) *Journal {
+		journal, err := OpenJournal(filename, "migrated", resume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrationData := &MigrationData{journal: journal,
+			newSink: func(ShardInfo) Sink { return sink }}
+		points := make(chan []TsmPoint, len(files))
+		for _, tsmPoints := range files {
+			points <- tsmPoints
+		}
+		close(points)
+		migrationData.WriteShard(shard, points)
+		return journal
+	}
+
+	//The shard fails after both files were written
+	crashed := &recordingSink{fail: true}
+	journal := writeShard(false, crashed)
+	journal.Close()
+
+	journal, err = OpenJournal(filename, "migrated", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.ShardDone(shard) {
+		t.Error("failed shard marked as done")
+	}
+	for _, wspFile := range []string{"a.wsp", "b.wsp"} {
+		if !journal.FileDone(shard, wspFile) {
+			t.Errorf("%s not recorded", wspFile)
+		}
+	}
+	if journal.FileDone(ShardInfo{id: "4", retentionPolicy: "default"}, "a.wsp") {
+		t.Error("a.wsp recorded for another shard")
+	}
+	journal.Close()
+
+	//Resuming writes no file again and completes the shard
+	resumed := &recordingSink{}
+	journal = writeShard(true, resumed)
+	if len(resumed.wspFiles) != 0 {
+		t.Errorf("resumed shard wrote %v again", resumed.wspFiles)
+	}
+	if !journal.ShardDone(shard) {
+		t.Error("resumed shard not marked as done")
+	}
+	journal.Close()
+
+	//A new migration starts from scratch
+	journal, err = OpenJournal(filename, "migrated", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.ShardDone(shard) || journal.FileDone(shard, "a.wsp") {
+		t.Error("journal not truncated without -resume")
+	}
+	journal.Close()
+}
+
+func TestJournalFileEntry(t *testing.T) {
+	journal := &Journal{database: "migrated"}
+	entry := journal.FileEntry(ShardInfo{id: "3", retentionPolicy: "default"},
+		"a.wsp", []TsmPoint{
+			{values: []tsm1.Value{tsm1.NewValue(time.Unix(120, 0), 1.0)}},
+			{values: []tsm1.Value{tsm1.NewValue(time.Unix(60, 0), 1.0)}},
+		})
+	expected := JournalEntry{Database: "migrated", RetentionPolicy: "default",
+		Shard: "3", WspFile: "a.wsp", LastTimestamp: 120}
+	if entry != expected {
+		t.Errorf("entry %+v, expected %+v", entry, expected)
+	}
+}
@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// LineProtocolSink writes the series of a shard as line protocol files which
+// can be imported with influx -import (-compressed for gzipped files). Every
+// file starts with the DDL and DML header of influx -import, when maxSize is
+// set a new file is started once a file has maxSize bytes of line protocol
+type LineProtocolSink struct {
+	basename        string
+	database        string
+	retentionPolicy string
+	compress        bool
+	maxSize         int64
+
+	part int
+	size int64
+	f    *os.File
+	gz   *gzip.Writer
+	w    *bufio.Writer
+}
+
+// Create a LineProtocolSink writing to basename.lp, or basename.lp.gz when
+// compressed. Files split by size are named basename_0001.lp and so on
+func NewLineProtocolSink(basename string, database string,
+	retentionPolicy string, compress bool, maxSize int64) *LineProtocolSink {
+
+	return &LineProtocolSink{basename: basename, database: database,
+		retentionPolicy: retentionPolicy, compress: compress, maxSize: maxSize}
+}
+
+func (sink *LineProtocolSink) filename() string {
+	filename := sink.basename
+	if sink.maxSize > 0 {
+		filename = fmt.Sprintf("%s_%04d", filename, sink.part)
+	}
+	filename = filename + ".lp"
+	if sink.compress {
+		filename = filename + ".gz"
+	}
+	return filename
+}
+
+func (sink *LineProtocolSink) Open() error {
+	sink.part = sink.part + 1
+	sink.size = 0
+	f, err := os.OpenFile(sink.filename(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+		0666)
+	if err != nil {
+		return err
+	}
+	sink.f = f
+
+	var w io.Writer = f
+	if sink.compress {
+		sink.gz = gzip.NewWriter(f)
+		w = sink.gz
+	}
+	sink.w = bufio.NewWriter(w)
+
+	//An existing retention policy fails to be created, the import goes on
+	_, err = fmt.Fprintf(sink.w, "# DDL\nCREATE DATABASE %q\n"+
+		"CREATE RETENTION POLICY %q ON %q DURATION INF REPLICATION 1\n\n"+
+		"# DML\n# CONTEXT-DATABASE: %s\n# CONTEXT-RETENTION-POLICY: %s\n\n",
+		sink.database, sink.retentionPolicy, sink.database, sink.database,
+		sink.retentionPolicy)
+	return err
+}
+
+func (sink *LineProtocolSink) WriteSeries(tsmPoint TsmPoint) error {
+	lines, err := LineProtocol(tsmPoint)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if sink.maxSize > 0 && sink.size >= sink.maxSize {
+			if err := sink.Close(); err != nil {
+				return err
+			}
+			if err := sink.Open(); err != nil {
+				return err
+			}
+		}
+		n, err := fmt.Fprintln(sink.w, line)
+		if err != nil {
+			return err
+		}
+		sink.size = sink.size + int64(n)
+	}
+	return nil
+}
+
+func (sink *LineProtocolSink) Close() error {
+	err := sink.w.Flush()
+	if sink.gz != nil {
+		if gzErr := sink.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := sink.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,947 @@
+// Package migration maps Graphite whisper files to InfluxDB series and writes
+// them as TSM files, through the HTTP API or as line protocol
+package migration
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+	"github.com/uttamgandhi/graphite-influx/config"
+	"github.com/uttamgandhi/graphite-influx/mapping"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func usage() {
+	log.Fatal(`graphite-influx migrate -wspPath=whisper folder -influxDataDir=influx data folder
+		-info -from=<2015-11-01> -until=<2015-12-30> -dbname=migrated -rp=default
+		-tagconfig=config.json | -templates=graphite.toml
+		-yes -on-unmatched=prompt|skip|fail|default-template -workers=8 -shardBatch=4
+		-archives=fetch|separate|stitch -downsample=5m
+		-on-collision=fail|merge|tag -mergeRule=last -collisionTag=metric
+		-include=servers.* -exclude=/\.tmp$/ -symlinks
+		-skip-stale=30d -staleBy=mtime|datapoint
+		-sanitize -aggregationTag=aggregation -aggregationReport=aggregation.csv
+		-journal=migration.journal -resume -verify -verifyReport=verify.csv
+		-influxAddr=http://localhost:8086 -influxUsername= -influxPassword=
+		-influxCACert= -influxInsecureSkipVerify -influxTimeout=30s
+		-dry-run -dryRunFormat=json|csv -dryRunOutput=plan.json -shardDuration=168h
+		-dry-run-shards -sink=tsm|http|lp|stdout -batchSize=5000 -retries=3 -backoff=1s
+		-lpDir=. -lpGzip -lpMaxSize=<bytes>`)
+}
+
+// A shard group and the shard of it which holds the data
+type ShardInfo struct {
+	id              json.Number
+	shardID         json.Number
+	retentionPolicy string
+	from            time.Time
+	until           time.Time
+}
+
+type MigrationData struct {
+	wspPath       string
+	influxDataDir string
+	from          time.Time
+	until         time.Time
+	dbName        string
+	rpName        string
+	wspFiles      []string
+	shards        []ShardInfo
+	mapper        *mapping.Mapper
+	addedPatterns bool
+	templates     *GraphiteTemplates
+	onUnmatched   string
+	workers       int
+	shardBatch    int
+	batch         int
+	archiveMode   string
+	downsample    uint32
+	aggrTag       string
+	aggrReport    *AggregationReport
+	verifyReport  *VerifyReport
+	sanitize      bool
+	include       MetricFilters
+	exclude       MetricFilters
+	symlinks      bool
+	skipStale     time.Duration
+	staleBy       string
+	mtfs          map[string]*mapping.MTF
+	onCollision   string
+	mergeRule     string
+	collisionTag  string
+	collisions    map[string]bool
+	skippedSlots  int64
+	mtfLock       sync.Mutex
+	journal       *Journal
+	newSink       SinkFactory
+	influxConfig  config.InfluxConfig
+}
+
+// Policies for whisper files which do not match any pattern
+const (
+	UnmatchedPrompt          = "prompt"
+	UnmatchedSkip            = "skip"
+	UnmatchedFail            = "fail"
+	UnmatchedDefaultTemplate = "default-template"
+)
+
+type TsmPoint struct {
+	key     string
+	values  []tsm1.Value
+	mtf     *mapping.MTF
+	wspFile string
+}
+
+// Run the migrate command with its command line arguments
+func Run(fs *flag.FlagSet, args []string) {
+	var (
+		wspPath       = fs.String("wspPath", "NULL", "Whisper files folder path")
+		influxDataDir = fs.String("influxDataDir", "NULL", "InfluxDB data directory")
+		from          = fs.String("from", "NULL", "from date in YYYY-MM-DD format")
+		until         = fs.String("until", "NULL", "until date in YYYY-MM-DD format")
+		dbName        = fs.String("dbname", "migrated", "Database name (default: migrated")
+		rpName        = fs.String("rp", "default", "Retention policy, created if it does not exist")
+		tagConfigFile = fs.String("tagconfig", "NULL", "Configuration file for measurement and tags")
+		templateFile  = fs.String("templates", "NULL", "InfluxDB graphite input style templates file, alternative to tagconfig")
+		yes           = fs.Bool("yes", false, "Migrate without asking for confirmation")
+		onUnmatched   = fs.String("on-unmatched", UnmatchedPrompt, "What to do with whisper files matching no pattern: prompt, skip, fail or default-template (fail with -yes)")
+		workers       = fs.Int("workers", runtime.NumCPU(), "Number of whisper files read in parallel")
+		shardBatch    = fs.Int("shardBatch", 4, "Number of shards migrated at a time, the points of a batch are held in memory and every whisper file is read once per batch")
+		downsample    = fs.Duration("downsample", 0, "Aggregate the points to this resolution with the aggregation method and xFilesFactor of each whisper file, -archives=fetch then reads the archives like stitch")
+		aggrTag       = fs.String("aggregationTag", "", "Tag key for the whisper aggregation method, not tagged if empty")
+		aggrReport    = fs.String("aggregationReport", "", "CSV file listing the aggregation method, xFilesFactor and archives of each whisper file")
+		onCollision   = fs.String("on-collision", CollisionFail, "What to do with whisper files mapped to the same series key: fail, merge or tag")
+		mergeRule     = fs.String("mergeRule", "last", "Rule for points of merged series with the same timestamp: first, last, max, min, sum or average")
+		collisionTag  = fs.String("collisionTag", "metric", "Tag key for the metric name of colliding whisper files with -on-collision=tag")
+		archiveMode   = fs.String("archives", ArchivesFetch, "Migrate the whisper archive covering each shard (fetch), every archive as its own measurement with a resolution suffix (separate) or all archives stitched by highest resolution (stitch)")
+		journalFile   = fs.String("journal", "migration.journal", "Checkpoint journal of completed shards and of the whisper files written to the other shards")
+		symlinks      = fs.Bool("symlinks", false, "Follow symlinks to whisper files and directories, they are skipped otherwise")
+		skipStale     = fs.String("skip-stale", "", "Skip whisper files last updated longer ago than this, e.g. 30d")
+		staleBy       = fs.String("staleBy", StaleByMtime, "Last update of a whisper file for -skip-stale: file modification time (mtime) or newest point (datapoint)")
+		sanitize      = fs.Bool("sanitize", false, "Replace characters which need escaping in series keys with _ instead of escaping them")
+		verify        = fs.Bool("verify", false, "Read every written TSM file back and compare it with the series written to it, failed TSM files are removed")
+		verifyReport  = fs.String("verifyReport", "verify.csv", "CSV file with the pass or fail of every shard verified with -verify")
+		resume        = fs.Bool("resume", false, "Skip the shards completed by a previous run")
+		dryRunShards  = fs.Bool("dry-run-shards", false, "List the shard groups which would be created and exit")
+		dryRun        = fs.Bool("dry-run", false, "Write the migration plan from the whisper headers and exit, without touching InfluxDB")
+		dryRunFormat  = fs.String("dryRunFormat", "json", "Format of the migration plan: json or csv")
+		dryRunOutput  = fs.String("dryRunOutput", "", "File of the migration plan, stdout if empty")
+		shardDuration = fs.Duration("shardDuration", 7*24*time.Hour, "Shard group duration assumed by -dry-run and the http, lp and stdout sinks")
+		sink          = fs.String("sink", "tsm", "Output: TSM files in influxDataDir (tsm), HTTP API of an existing database (http), line protocol files in lpDir (lp) or stdout")
+		lpDir         = fs.String("lpDir", ".", "Directory of the line protocol files of the lp sink")
+		lpGzip        = fs.Bool("lpGzip", false, "Gzip the line protocol files, import with influx -import -compressed")
+		lpMaxSize     = fs.Int64("lpMaxSize", 0, "Start a new line protocol file after this many bytes, 0 for one file per shard")
+		batchSize     = fs.Int("batchSize", 5000, "Points per HTTP write")
+		retries       = fs.Int("retries", 3, "Retries of a failed HTTP write")
+		backoff       = fs.Duration("backoff", time.Second, "Wait before the first retry of a HTTP write, doubled for every retry")
+	)
+	var include, exclude MetricFilters
+	fs.Var(&include, "include", "Only migrate metrics matching this glob or /regex/, can be repeated")
+	fs.Var(&exclude, "exclude", "Do not migrate metrics matching this glob or /regex/, can be repeated")
+	var influxConfig config.InfluxConfig
+	influxConfig.RegisterFlags(fs)
+	fs.Parse(args)
+	influxConfig.ReadEnv(fs)
+	if *wspPath == "NULL" ||
+		(*tagConfigFile == "NULL" && *templateFile == "NULL") {
+		usage()
+	}
+	//Only TSM files are written to the data directory
+	if *sink == "tsm" && *influxDataDir == "NULL" && !*dryRun && !*dryRunShards {
+		usage()
+	}
+	if *workers < 1 || *shardBatch < 1 || *batchSize < 1 {
+		usage()
+	}
+	if *verify && *sink != "tsm" {
+		log.Fatal("-verify needs the tsm sink")
+	}
+	switch *archiveMode {
+	case ArchivesFetch, ArchivesSeparate, ArchivesStitch:
+	default:
+		usage()
+	}
+	switch *onCollision {
+	case CollisionFail, CollisionMerge, CollisionTag:
+	default:
+		usage()
+	}
+	if _, ok := mergeRules[*mergeRule]; !ok {
+		usage()
+	}
+	//Without confirmation nothing can be prompted for either
+	if *yes {
+		onUnmatchedSet := false
+		fs.Visit(func(f *flag.Flag) {
+			onUnmatchedSet = onUnmatchedSet || f.Name == "on-unmatched"
+		})
+		if !onUnmatchedSet {
+			*onUnmatched = UnmatchedFail
+		} else if *onUnmatched == UnmatchedPrompt {
+			log.Fatal("-yes can not be used with -on-unmatched=prompt")
+		}
+	}
+	switch *onUnmatched {
+	case UnmatchedPrompt, UnmatchedSkip, UnmatchedFail, UnmatchedDefaultTemplate:
+	default:
+		usage()
+	}
+	switch *staleBy {
+	case StaleByMtime, StaleByDatapoint:
+	default:
+		usage()
+	}
+	var staleAge time.Duration
+	if *skipStale != "" {
+		var err error
+		if staleAge, err = ParseAge(*skipStale); err != nil {
+			log.Fatal("Error in parsing skip-stale ", err)
+		}
+	}
+	migrationData := &MigrationData{dbName: *dbName, rpName: *rpName,
+		wspPath: *wspPath, influxDataDir: *influxDataDir,
+		onUnmatched: *onUnmatched, workers: *workers, shardBatch: *shardBatch,
+		archiveMode: *archiveMode, downsample: uint32(*downsample / time.Second),
+		aggrTag: *aggrTag, sanitize: *sanitize, onCollision: *onCollision,
+		mergeRule: *mergeRule, collisionTag: *collisionTag, include: include,
+		exclude: exclude, symlinks: *symlinks, skipStale: staleAge,
+		staleBy: *staleBy, influxConfig: influxConfig}
+
+	if *from == "NULL" {
+		*from = "2008-01-01" //TODO: check if this is correct assumption the date is
+	} // based on graphite project's github project
+
+	var err error
+	migrationData.from, err = time.Parse("2006-01-02", *from)
+
+	if err != nil {
+		log.Fatal("Error in parsing from ")
+	}
+
+	if *until != "NULL" {
+		migrationData.until, err = time.Parse("2006-01-02", *until)
+		if err != nil {
+			log.Fatal("Error in parsing until ")
+		}
+	} else {
+		migrationData.until = time.Now()
+	}
+
+	if *templateFile != "NULL" {
+		migrationData.templates, err = ReadGraphiteTemplates(*templateFile)
+		if err != nil {
+			log.Fatal("Error in reading templates ", err)
+		}
+		//Patterns entered for unmatched files are kept in an empty mapper
+		migrationData.mapper, _ = mapping.NewMapper(nil)
+	} else {
+		migrationData.ReadTagConfig(*tagConfigFile)
+	}
+	migrationData.FindWhisperFiles(*wspPath)
+	if *dryRun {
+		if err = migrationData.WritePlan(*shardDuration, *dryRunFormat,
+			*dryRunOutput); err != nil {
+			log.Fatal("Error in writing migration plan ", err)
+		}
+		return
+	}
+	migrationData.PreviewMTF()
+	//Update the config file with the patterns entered for unmatched files
+	if *tagConfigFile != "NULL" && migrationData.addedPatterns {
+		migrationData.WriteConfigFile(*tagConfigFile)
+	}
+	if err = migrationData.ResolveCollisions(); err != nil {
+		log.Fatal("Error in mapping whisper files ", err)
+	}
+	if *dryRunShards {
+		if err = migrationData.CreateShards(true); err != nil {
+			log.Fatal("Error in listing shard groups ", err)
+		}
+		return
+	}
+	//After the preview, confirm if the user wants to migrate data
+	if !*yes {
+		fmt.Fprintln(os.Stderr, "Do you want to continue the migration? YES/NO :")
+		if userInput, _ := readInput("answer", true); userInput != "YES" {
+			return
+		}
+	}
+	migrationData.journal, err = OpenJournal(*journalFile, *dbName, *resume)
+	if err != nil {
+		log.Fatal("Error in opening journal ", err)
+	}
+	defer migrationData.journal.Close()
+	if *aggrReport != "" {
+		migrationData.aggrReport, err = NewAggregationReport(*aggrReport)
+		if err != nil {
+			log.Fatal("Error in creating aggregation report ", err)
+		}
+		defer migrationData.aggrReport.Close()
+	}
+	if *verify {
+		migrationData.verifyReport, err = NewVerifyReport(*verifyReport)
+		if err != nil {
+			log.Fatal("Error in creating verify report ", err)
+		}
+		defer migrationData.verifyReport.Close()
+	}
+	switch *sink {
+	case "tsm":
+		migrationData.newSink = func(shard ShardInfo) Sink {
+			return NewTSMSink(migrationData.GetShardDir(shard))
+		}
+	case "http":
+		httpClient, err := influxConfig.HTTPClient()
+		if err != nil {
+			log.Fatal("Error in creating HTTP client ", err)
+		}
+		migrationData.newSink = func(shard ShardInfo) Sink {
+			return NewHTTPSink(influxConfig, httpClient, migrationData.dbName,
+				migrationData.rpName, *batchSize, *retries, *backoff)
+		}
+	case "lp":
+		migrationData.newSink = func(shard ShardInfo) Sink {
+			basename := fmt.Sprintf("%v_%v", migrationData.dbName, shard.id)
+			return NewLineProtocolSink(filepath.Join(*lpDir, basename),
+				migrationData.dbName, migrationData.rpName, *lpGzip, *lpMaxSize)
+		}
+	case "stdout":
+		migrationData.newSink = func(shard ShardInfo) Sink {
+			return NewStdoutSink()
+		}
+	default:
+		usage()
+	}
+	switch *sink {
+	case "http", "lp", "stdout":
+		//InfluxDB creates the shards of written points and line protocol is
+		//imported later, the shard groups are only planned so no admin
+		//queries are needed
+		migrationData.PlanShards(*shardDuration)
+	default:
+		// Create shards for given time ranges
+		if err = migrationData.CreateShards(false); err != nil {
+			log.Fatal("Error in creating shards ", err)
+		}
+	}
+	//Map WSP to TSM
+	migrationData.MapWSPToTSMByShard()
+}
+
+// Build the migration plan and write it as json or csv to filename, or to
+// stdout if filename is empty
+func (migrationData *MigrationData) WritePlan(shardDuration time.Duration,
+	format string, filename string) error {
+
+	plan := migrationData.BuildPlan(shardDuration)
+	w := os.Stdout
+	if filename != "" {
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch format {
+	case "json":
+		return plan.WriteJSON(w)
+	case "csv":
+		return plan.WriteCSV(w)
+	}
+	return fmt.Errorf("unknown format %v", format)
+}
+
+// Read the config file and create migrationData.mapper
+func (migrationData *MigrationData) ReadTagConfig(filename string) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	var tagConfigs []mapping.TagConfig
+	if err := json.Unmarshal(raw, &tagConfigs); err != nil {
+		log.Fatal("Error in reading tag config ", err)
+	}
+	if migrationData.mapper, err = mapping.NewMapper(tagConfigs); err != nil {
+		log.Fatal("Error in tag config ", err)
+	}
+	migrationData.mapper.Sanitize = migrationData.sanitize
+}
+
+//Write the tag configs of migrationData.mapper to file
+func (migrationData *MigrationData) WriteConfigFile(filename string) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "File Open Error")
+		return
+	}
+	configStr, _ := json.MarshalIndent(migrationData.mapper.TagConfigs(), "",
+		"  ")
+	_, err = f.WriteString(string(configStr))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Write Error")
+		return
+	}
+	f.Close()
+}
+
+// Creates new config as per user's input. Returns an error if the input ends
+// or is malformed
+func NewConfig() (*mapping.TagConfig, error) {
+	newTagConfig := &mapping.TagConfig{}
+	fmt.Fprintln(os.Stderr, `Tag config does not exist, You will be prompted to enter
+				Pattern Measurement tags and field`)
+	fmt.Fprintln(os.Stderr, `Please enter pattern e.g. carbon.agents.#TEXT1.#TEXT2.#TEXT3
+		 or a regex with named groups e.g. ^carbon\.agents\.(?P<host>[^.]+)\.(?P<m>.+)$
+		 Look at the migration_config.json for more examples`)
+
+	var err error
+	if newTagConfig.Pattern, err = readInput("pattern", true); err != nil {
+		return nil, err
+	}
+	if _, err = newTagConfig.Regexp(); err != nil {
+		return nil, &mapping.PatternError{Pattern: newTagConfig.Pattern, Err: err}
+	}
+
+	fmt.Fprintln(os.Stderr, `Please enter measurement e.g. #TEXT3 ,\n#TEXT3 will be replaced
+		with actual value`)
+	if newTagConfig.Measurement, err = readInput("measurement", true); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(os.Stderr, `Please enter tags e.g. host=#TEXT1 loc=#TEXT2
+		\n host and loc are the tag keys and #TEXT1, #TEXT2 will be replaced
+		actual tag values`)
+
+	tagDataStr, err := readInput("tags", false)
+	if err != nil {
+		return nil, err
+	}
+	for _, tagDataString := range strings.Fields(tagDataStr) {
+		tagKeyValueStr := strings.SplitN(tagDataString, "=", 2)
+		if len(tagKeyValueStr) != 2 || tagKeyValueStr[0] == "" ||
+			tagKeyValueStr[1] == "" {
+			return nil, fmt.Errorf("tag %q is not key=value", tagDataString)
+		}
+		newTagConfig.Tags = append(newTagConfig.Tags, mapping.TagKeyValue{
+			Tagkey: tagKeyValueStr[0], Tagvalue: tagKeyValueStr[1]})
+	}
+
+	fmt.Fprintln(os.Stderr, `Please enter Field e.g. value`)
+	if newTagConfig.Field, err = readInput("field", true); err != nil {
+		return nil, err
+	}
+	return newTagConfig, nil
+}
+
+// Standard input of the prompts, shared so no buffered input is lost
+var stdin = bufio.NewReader(os.Stdin)
+
+// Read a line of user input, trimmed. The end of the input is an error, as is
+// an empty line if required
+func readInput(name string, required bool) (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return "", fmt.Errorf("no %s entered: end of input", name)
+		}
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if required && line == "" {
+		return "", fmt.Errorf("no %s entered", name)
+	}
+	return line, nil
+}
+
+// Find all whisper files from a given wspPath which pass the -include,
+// -exclude and -skip-stale filters
+func (migrationData *MigrationData) FindWhisperFiles(searchDir string) {
+	fileList := []string{}
+	now := time.Now()
+	excluded, stale := 0, 0
+	err := migrationData.walkWhisperFiles(searchDir, make(map[string]bool),
+		func(path string, f os.FileInfo) {
+			if !migrationData.IncludeMetric(migrationData.MetricName(path)) {
+				excluded = excluded + 1
+				return
+			}
+			isStale, err := migrationData.IsStale(path, f, now)
+			if err != nil {
+				log.Println("Error in reading", path, err)
+			}
+			if isStale {
+				stale = stale + 1
+				return
+			}
+			fileList = append(fileList, path)
+		})
+	if err != nil {
+		log.Println("Error in finding whisper files", err)
+	}
+	if excluded > 0 || stale > 0 {
+		log.Println("Filtered out", excluded, "excluded and", stale,
+			"stale whisper files")
+	}
+	migrationData.wspFiles = fileList
+}
+
+// Gives a preview how the measurements, tags and fields look like for given
+// whisper files and config file. Also will take input for new config if does
+// not exist already for a given pattern. Files skipped by the -on-unmatched
+// policy are removed from the migration, the mapping of the others is kept.
+// The preview and the prompts go to stderr, stdout is left to the stdout sink
+func (migrationData *MigrationData) PreviewMTF() {
+	var wspFiles []string
+	migrationData.mtfs = make(map[string]*mapping.MTF)
+	for _, wspFile := range migrationData.wspFiles {
+		mtf := migrationData.ResolveMTF(wspFile)
+		if mtf == nil {
+			continue
+		}
+		wspFiles = append(wspFiles, wspFile)
+		migrationData.mtfs[wspFile] = mtf
+		key := mapping.CreateTSMKey(mtf)
+		log.Println("Whisper File", wspFile, "TSM Key->", key)
+	}
+	migrationData.wspFiles = wspFiles
+}
+
+// Get measurement, tags and field for a whisper file, if no pattern matches
+// the -on-unmatched policy decides. Returns nil if the file is to be skipped
+func (migrationData *MigrationData) ResolveMTF(wspFile string) *mapping.MTF {
+	if mtf := migrationData.GetMTF(wspFile); mtf != nil {
+		return mtf
+	}
+	switch migrationData.onUnmatched {
+	case UnmatchedSkip:
+		log.Println("Skipping unmatched whisper file", wspFile)
+		return nil
+	case UnmatchedFail:
+		log.Fatal("No pattern matches whisper file ", wspFile)
+	case UnmatchedDefaultTemplate:
+		log.Println("Using default template for unmatched whisper file", wspFile)
+		templates, _ := NewGraphiteTemplates(GraphiteConfig{})
+		return migrationData.NormalizeMTF(
+			templates.GetMTF(migrationData.MetricName(wspFile)))
+	}
+	//Create and add the pattern
+	tagConfig, err := NewConfig()
+	if err != nil {
+		log.Fatal("Error in reading tag config ", err)
+	}
+	if err := migrationData.mapper.Add(*tagConfig); err != nil {
+		log.Println("Error in tag config", err)
+	} else {
+		migrationData.addedPatterns = true
+	}
+	return migrationData.NormalizeMTF(&mapping.MTF{Measurement: tagConfig.Measurement,
+		Tags: tagConfig.Tags, Field: tagConfig.Field})
+}
+
+// Time range of a shard clamped to the migration's from and until
+type ShardWindow struct {
+	shard ShardInfo
+	from  time.Time
+	until time.Time
+}
+
+// Get the time range to migrate for every shard, shards completed according
+// to the journal are left out
+func (migrationData *MigrationData) ShardWindows() []ShardWindow {
+	var windows []ShardWindow
+	for _, shard := range migrationData.shards {
+		if migrationData.journal != nil && migrationData.journal.ShardDone(shard) {
+			log.Println("Skipping completed shard", shard.id)
+			continue
+		}
+		window := ShardWindow{shard: shard, from: shard.from, until: shard.until}
+		if shard.from.Before(migrationData.from) {
+			window.from = migrationData.from
+		}
+		if shard.until.After(migrationData.until) {
+			window.until = migrationData.until
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// Migrates all whisper files, shardBatch shards at a time so only the points
+// of a batch are held in memory
+func (migrationData *MigrationData) MapWSPToTSMByShard() {
+	windows := migrationData.ShardWindows()
+	for start := 0; start < len(windows); start = start + migrationData.shardBatch {
+		end := start + migrationData.shardBatch
+		if end > len(windows) {
+			end = len(windows)
+		}
+		migrationData.batch = start / migrationData.shardBatch
+		migrationData.MapWSPToTSMByShards(windows[start:end])
+	}
+	log.Println("Skipped", atomic.LoadInt64(&migrationData.skippedSlots),
+		"null or stale whisper slots")
+	if migrationData.verifyReport != nil {
+		passed, failed := migrationData.verifyReport.Counts()
+		log.Println("Verified", passed+failed, "shards,", failed, "failed")
+	}
+}
+
+// Migrates all whisper files to a batch of shards with a pipeline: the
+// whisper files are fed to a pool of workers, each worker opens a whisper file
+// once and maps its points for every overlapping shard, the series of a
+// whisper file are written together to the Sink of the shard by one writer
+// per shard
+func (migrationData *MigrationData) MapWSPToTSMByShards(windows []ShardWindow) {
+	//Per shard writers
+	var writers sync.WaitGroup
+	shardPoints := make([]chan []TsmPoint, len(windows))
+	for i := range windows {
+		shardPoints[i] = make(chan []TsmPoint, migrationData.workers)
+		writers.Add(1)
+		go func(window ShardWindow, files <-chan []TsmPoint) {
+			defer writers.Done()
+			migrationData.WriteShard(window.shard, files)
+		}(windows[i], shardPoints[i])
+	}
+
+	//Whisper readers and point mappers
+	var readers sync.WaitGroup
+	wspFiles := make(chan string, migrationData.workers)
+	for i := 0; i < migrationData.workers; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for wspFile := range wspFiles {
+				tsmPoints := migrationData.MapWSPToTSMByWhisperFile(wspFile, windows)
+				for shardIndex, shardTsmPoints := range tsmPoints {
+					shardPoints[shardIndex] <- shardTsmPoints
+				}
+			}
+		}()
+	}
+
+	//File walker
+	for _, wspFile := range migrationData.wspFiles {
+		wspFiles <- wspFile
+	}
+	close(wspFiles)
+	readers.Wait()
+
+	for _, points := range shardPoints {
+		close(points)
+	}
+	writers.Wait()
+}
+
+// Count the null and stale slots skipped in a whisper file
+func (migrationData *MigrationData) CountSkipped(wspFile string, skipped int) {
+	if skipped == 0 {
+		return
+	}
+	log.Println("Skipped", skipped, "null or stale slots in", wspFile)
+	atomic.AddInt64(&migrationData.skippedSlots, int64(skipped))
+}
+
+// Whisper file passed to the Sink of a shard, recorded in the journal once
+// the sink has written points points
+type fileProgress struct {
+	entry  JournalEntry
+	points int64
+}
+
+// Writes the series of a shard to its Sink, one slice of series per whisper
+// file, and records the whisper files and the shard in the journal. A
+// ResumableSink skips the whisper files already recorded for the shard. The
+// files channel is drained even if the Sink fails, so the workers are never
+// blocked
+func (migrationData *MigrationData) WriteShard(shard ShardInfo,
+	files <-chan []TsmPoint) {
+
+	//Series of colliding files are held back and merged at the end
+	merge := make(map[string][]TsmPoint)
+	var mergeFiles []JournalEntry
+	//Series passed to the sink, kept with -verify
+	var written []TsmPoint
+	//Whisper files waiting for the sink to write their points
+	var pending []fileProgress
+	var passed int64
+	sink := migrationData.newSink(shard)
+	resumable, ok := sink.(ResumableSink)
+	if !ok || migrationData.journal == nil {
+		resumable = nil
+	}
+	writeSeries := func(tsmPoint TsmPoint) error {
+		if migrationData.verifyReport != nil {
+			written = append(written, tsmPoint)
+		}
+		passed = passed + int64(len(tsmPoint.values))
+		return sink.WriteSeries(tsmPoint)
+	}
+	err := sink.Open()
+	for tsmPoints := range files {
+		if err != nil || len(tsmPoints) == 0 {
+			continue
+		}
+		wspFile := tsmPoints[0].wspFile
+		if resumable != nil && migrationData.journal.FileDone(shard, wspFile) {
+			continue
+		}
+		var entry JournalEntry
+		if resumable != nil {
+			entry = migrationData.journal.FileEntry(shard, wspFile, tsmPoints)
+		}
+		if migrationData.collisions[wspFile] {
+			for _, tsmPoint := range tsmPoints {
+				merge[tsmPoint.key] = append(merge[tsmPoint.key], tsmPoint)
+			}
+			mergeFiles = append(mergeFiles, entry)
+			continue
+		}
+		for _, tsmPoint := range tsmPoints {
+			if err = writeSeries(tsmPoint); err != nil {
+				break
+			}
+		}
+		if err == nil && resumable != nil {
+			pending = append(pending, fileProgress{entry: entry, points: passed})
+			pending = migrationData.RecordFiles(resumable, pending)
+		}
+	}
+	for _, tsmPoints := range merge {
+		if err != nil {
+			break
+		}
+		err = writeSeries(MergeTsmPoints(tsmPoints, migrationData.mergeRule))
+	}
+	if resumable != nil {
+		for _, entry := range mergeFiles {
+			pending = append(pending, fileProgress{entry: entry, points: passed})
+		}
+	}
+	if err == nil {
+		err = sink.Close()
+	}
+	if err != nil {
+		log.Println("Error in writing shard", shard.id, err)
+		return
+	}
+	if !migrationData.VerifyShard(shard, written, sink) {
+		return
+	}
+	if migrationData.journal == nil {
+		return
+	}
+	if resumable != nil {
+		migrationData.RecordFiles(resumable, pending)
+	}
+	if err := migrationData.journal.RecordShard(shard); err != nil {
+		log.Println("Error in writing journal", err)
+	}
+}
+
+// Record the pending whisper files whose points the sink has written in the
+// journal, the files still pending are returned
+func (migrationData *MigrationData) RecordFiles(sink ResumableSink,
+	pending []fileProgress) []fileProgress {
+
+	written := sink.Written()
+	var entries []JournalEntry
+	for len(pending) > 0 && pending[0].points <= written {
+		entries = append(entries, pending[0].entry)
+		pending = pending[1:]
+	}
+	if err := migrationData.journal.RecordFiles(entries); err != nil {
+		log.Println("Error in writing journal", err)
+	}
+	return pending
+}
+
+// Verify what the sink of a shard wrote against the series passed to it if
+// -verify is set and add the result to the report. A shard failing
+// verification is not recorded in the journal
+func (migrationData *MigrationData) VerifyShard(shard ShardInfo,
+	written []TsmPoint, sink Sink) bool {
+
+	verifiable, ok := sink.(VerifiableSink)
+	if migrationData.verifyReport == nil || !ok {
+		return true
+	}
+	verification := verifiable.Verify(SummarizeSeries(written))
+	verification.Shard = shard
+	if err := migrationData.verifyReport.Add(verification); err != nil {
+		log.Println("Error in writing verify report", err)
+	}
+	if !verification.Passed() {
+		log.Println("Shard", shard.id, "failed verification:",
+			strings.Join(verification.Mismatches, "; "))
+	}
+	return verification.Passed()
+}
+
+// Opens a whisper file and maps its data points to TSM data points for every
+// shard window it overlaps, keyed by the index of the window. This is just
+// mapping points from one Data structure to other not writing to files
+func (migrationData *MigrationData) MapWSPToTSMByWhisperFile(wspFile string,
+	windows []ShardWindow) map[int][]TsmPoint {
+
+	w, err := whisper.Open(wspFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+
+	mtf := migrationData.mtfs[wspFile]
+	if mtf == nil {
+		//ResolveMTF may prompt for and add a new config
+		migrationData.mtfLock.Lock()
+		mtf = migrationData.ResolveMTF(wspFile)
+		migrationData.mtfLock.Unlock()
+	}
+	if mtf == nil {
+		return nil
+	}
+	metadata := w.Header.Metadata
+	mtf = migrationData.AggregationMTF(mtf, metadata.AggregationMethod)
+	//A whisper file is read once per shard batch, it is reported once
+	if migrationData.aggrReport != nil && migrationData.batch == 0 {
+		err := migrationData.aggrReport.Add(wspFile, mapping.CreateTSMKey(mtf), w.Header)
+		if err != nil {
+			log.Println("Error in writing aggregation report", err)
+		}
+	}
+
+	mode := migrationData.ReadMode()
+	if mode != ArchivesFetch {
+		archives, skipped, err := ReadArchives(w, time.Now())
+		if err != nil {
+			log.Fatal(wspFile, ": ", err)
+		}
+		if migrationData.batch == 0 {
+			migrationData.CountSkipped(wspFile, skipped)
+		}
+		for i := range archives {
+			archives[i].points = Downsample(archives[i].points,
+				archives[i].archive.SecondsPerPoint, migrationData.downsample,
+				metadata.AggregationMethod, metadata.XFilesFactor)
+		}
+		tsmPoints := make(map[int][]TsmPoint)
+		for _, series := range ArchiveSeries(archives, mtf, mode) {
+			for i, window := range windows {
+				wspPoints := PointsInRange(series.points, window.from, window.until)
+				if len(wspPoints) == 0 {
+					continue
+				}
+				tsmPoints[i] = append(tsmPoints[i],
+					NewTsmPoint(series.mtf, wspFile, wspPoints))
+			}
+		}
+		return tsmPoints
+	}
+
+	now := time.Now()
+	tsmPoints := make(map[int][]TsmPoint)
+	skipped := 0
+	for i, window := range windows {
+		wspPoints, _, windowSkipped, err := mapping.FetchPoints(w,
+			window.from, window.until, now)
+		if err != nil {
+			log.Fatal(wspFile, ": ", err)
+		}
+		skipped = skipped + windowSkipped
+		wspPoints = PointsInRange(wspPoints, window.from, window.until)
+		if len(wspPoints) == 0 {
+			continue
+		}
+		tsmPoints[i] = append(tsmPoints[i], NewTsmPoint(mtf, wspFile, wspPoints))
+	}
+	migrationData.CountSkipped(wspFile, skipped)
+	return tsmPoints
+}
+
+// Archive mode the whisper files are read with. With -downsample the fetch
+// mode reads them like the stitch mode: every archive is downsampled with its
+// own step and every period gets its highest resolution, so a shard never
+// gets a coarser archive than it has and no resolution interval is split at a
+// shard edge
+func (migrationData *MigrationData) ReadMode() string {
+	if migrationData.archiveMode == ArchivesFetch && migrationData.downsample != 0 {
+		return ArchivesStitch
+	}
+	return migrationData.archiveMode
+}
+
+// Tag the series with the aggregation method of the whisper file if
+// -aggregationTag is set
+func (migrationData *MigrationData) AggregationMTF(mtf *mapping.MTF,
+	method whisper.AggregationMethod) *mapping.MTF {
+
+	if migrationData.aggrTag == "" {
+		return mtf
+	}
+	aggrMTF := *mtf
+	aggrMTF.Tags = append(append([]mapping.TagKeyValue{}, mtf.Tags...),
+		mapping.TagKeyValue{Tagkey: migrationData.aggrTag,
+			Tagvalue: method.String()})
+	return migrationData.NormalizeMTF(&aggrMTF)
+}
+
+// Map whisper points of a series to a TsmPoint
+func NewTsmPoint(mtf *mapping.MTF, wspFile string, wspPoints []whisper.Point) TsmPoint {
+	return TsmPoint{key: mapping.CreateTSMKey(mtf), mtf: mtf, wspFile: wspFile,
+		values: mapping.NewValues(wspPoints)}
+}
+
+// Path of the shard's directory,
+// <influxDataDir>/<database>/<retention policy>/<shard id>
+func (migrationData *MigrationData) GetShardDir(shard ShardInfo) string {
+	return filepath.Join(migrationData.influxDataDir, migrationData.dbName,
+		shard.retentionPolicy, shard.shardID.String())
+}
+
+// Get the dotted graphite metric name of a whisper file, relative to wspPath
+// e.g. /data/whisper/carbon/agents/host1/cpu.wsp -> carbon.agents.host1.cpu
+func (migrationData *MigrationData) MetricName(wspFilename string) string {
+	return mapping.MetricName(migrationData.wspPath, wspFilename)
+}
+
+// Get measurement, tags and field by matching the whisper filename with a
+// pattern in the config file, or with the graphite templates if given
+func (migrationData *MigrationData) GetMTF(wspFilename string) *mapping.MTF {
+	mtf, _ := migrationData.MatchMTF(wspFilename)
+	return mtf
+}
+
+// Get measurement, tags and field of a whisper file and the pattern or
+// template which matched it, nil if nothing matched. The tags are sorted by
+// key
+func (migrationData *MigrationData) MatchMTF(wspFilename string) (*mapping.MTF, string) {
+	mtf, pattern := migrationData.matchMTF(wspFilename)
+	return migrationData.NormalizeMTF(mtf), pattern
+}
+
+func (migrationData *MigrationData) matchMTF(wspFilename string) (*mapping.MTF, string) {
+	metricName := migrationData.MetricName(wspFilename)
+	if migrationData.templates != nil {
+		return migrationData.templates.MatchMTF(metricName)
+	}
+	return migrationData.mapper.Match(metricName)
+}
+
+// Sort the tags by key and sanitize the names with -sanitize
+func (migrationData *MigrationData) NormalizeMTF(mtf *mapping.MTF) *mapping.MTF {
+	return mapping.NormalizeMTF(mtf, migrationData.sanitize)
+}
@@ -0,0 +1,273 @@
+package migration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uttamgandhi/graphite-influx/mapping"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// Whisper files with different retentions fetched for shard windows before,
+// across and after their retention
+func TestMapWSPToTSMByWhisperFileRetentions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphite-influx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().Truncate(time.Minute)
+	files := []struct {
+		name     string
+		archives []whisper.ArchiveInfo
+		// Points are written every minute since then, within the retention
+		since time.Duration
+	}{
+		{name: "hour", since: 24 * time.Hour,
+			archives: []whisper.ArchiveInfo{whisper.NewArchiveInfo(60, 60)}},
+		{name: "day", since: 24 * time.Hour,
+			archives: []whisper.ArchiveInfo{whisper.NewArchiveInfo(60, 60),
+				whisper.NewArchiveInfo(600, 144)}},
+		//The oldest point is after the start of the last window
+		{name: "new", since: 20 * time.Minute,
+			archives: []whisper.ArchiveInfo{whisper.NewArchiveInfo(60, 240)}},
+	}
+	migrationData := &MigrationData{archiveMode: ArchivesFetch,
+		mtfs: make(map[string]*mapping.MTF)}
+	retentions := make(map[string]time.Duration)
+	for _, file := range files {
+		wspFile := filepath.Join(dir, file.name+".wsp")
+		w, err := whisper.Create(wspFile, file.archives, whisper.CreateOptions{
+			AggregationMethod: whisper.AggregationAverage})
+		if err != nil {
+			t.Fatal(err)
+		}
+		retention := time.Duration(w.Header.Metadata.MaxRetention) * time.Second
+		from := now.Add(-file.since)
+		if oldest := now.Add(-retention + time.Minute); from.Before(oldest) {
+			from = oldest
+		}
+		var points []whisper.Point
+		for ts := from; !ts.After(now); ts = ts.Add(time.Minute) {
+			points = append(points, whisper.Point{Timestamp: uint32(ts.Unix()),
+				Value: float64(ts.Unix())})
+		}
+		if err := w.UpdateMany(points); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		migrationData.mtfs[wspFile] = &mapping.MTF{Measurement: file.name,
+			Field: "value"}
+		retentions[file.name] = retention
+	}
+
+	windows := []ShardWindow{
+		{from: now.Add(-72 * time.Hour), until: now.Add(-48 * time.Hour)},
+		{from: now.Add(-5 * time.Hour), until: now.Add(-2 * time.Hour)},
+		{from: now.Add(-2 * time.Hour), until: now.Add(-30 * time.Minute)},
+		{from: now.Add(-30 * time.Minute), until: now.Add(time.Hour)},
+	}
+	tests := []struct {
+		file   string
+		window int
+		// Step of the points, 0 if the window gets none
+		step uint32
+		// First point, the clamped start of the window if zero
+		first time.Time
+	}{
+		{file: "hour", window: 0},
+		{file: "hour", window: 1},
+		//The retention starts after the start of the window
+		{file: "hour", window: 2, step: 60},
+		{file: "hour", window: 3, step: 60, first: windows[3].from},
+		{file: "day", window: 0},
+		{file: "day", window: 1, step: 600},
+		{file: "day", window: 2, step: 600},
+		{file: "day", window: 3, step: 60, first: windows[3].from},
+		{file: "new", window: 0},
+		{file: "new", window: 1},
+		{file: "new", window: 2},
+		{file: "new", window: 3, step: 60, first: now.Add(-20 * time.Minute)},
+	}
+
+	results := make(map[string]map[int][]TsmPoint)
+	for _, file := range files {
+		wspFile := filepath.Join(dir, file.name+".wsp")
+		results[file.name] = migrationData.MapWSPToTSMByWhisperFile(wspFile,
+			windows)
+	}
+	for _, test := range tests {
+		window := windows[test.window]
+		tsmPoints := results[test.file][test.window]
+		if test.step == 0 {
+			if len(tsmPoints) != 0 {
+				t.Errorf("%s window %d: %d series, expected none", test.file,
+					test.window, len(tsmPoints))
+			}
+			continue
+		}
+		if len(tsmPoints) != 1 {
+			t.Errorf("%s window %d: %d series, expected 1", test.file,
+				test.window, len(tsmPoints))
+			continue
+		}
+		values := tsmPoints[0].values
+		first := values[0].Time()
+		last := values[len(values)-1].Time()
+		step := time.Duration(test.step) * time.Second
+
+		//The window clamped to the retention of the file and now
+		from, until := window.from, window.until
+		if oldest := now.Add(-retentions[test.file]); from.Before(oldest) {
+			from = oldest
+		}
+		if until.After(time.Now()) {
+			until = time.Now()
+		}
+		if test.first.IsZero() {
+			if first.Before(from) || first.After(from.Add(2*step)) {
+				t.Errorf("%s window %d: first point %v, expected within %v of %v",
+					test.file, test.window, first, step, from)
+			}
+		} else if !first.Equal(test.first) {
+			t.Errorf("%s window %d: first point %v, expected %v", test.file,
+				test.window, first, test.first)
+		}
+		if !last.Before(until) || last.Before(until.Add(-2*step)) {
+			t.Errorf("%s window %d: last point %v, expected within %v before %v",
+				test.file, test.window, last, step, until)
+		}
+		for i, value := range values {
+			if value.UnixNano()%int64(step) != 0 {
+				t.Errorf("%s window %d: point %v is not a multiple of %v",
+					test.file, test.window, value.Time(), step)
+			}
+			if i > 0 && value.Time().Sub(values[i-1].Time()) != step {
+				t.Errorf("%s window %d: gap between %v and %v", test.file,
+					test.window, values[i-1].Time(), value.Time())
+			}
+		}
+	}
+
+	//Adjacent windows read from the same archive continue each other, no
+	//point is lost or written twice at the window edge
+	for _, edge := range []struct {
+		file  string
+		left  int
+		right int
+		step  time.Duration
+	}{
+		{file: "day", left: 1, right: 2, step: 10 * time.Minute},
+		{file: "hour", left: 2, right: 3, step: time.Minute},
+	} {
+		left := results[edge.file][edge.left][0].values
+		right := results[edge.file][edge.right][0].values
+		gap := right[0].Time().Sub(left[len(left)-1].Time())
+		if gap != edge.step {
+			t.Errorf("%s windows %d and %d: %v between the last and first point, expected %v",
+				edge.file, edge.left, edge.right, gap, edge.step)
+		}
+	}
+}
+
+// With -downsample every shard gets the highest resolution covering it, not
+// the archive of the oldest shard of the batch
+func TestMapWSPToTSMByWhisperFileDownsample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphite-influx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().Truncate(time.Hour)
+	wspFile := filepath.Join(dir, "day.wsp")
+	w, err := whisper.Create(wspFile, []whisper.ArchiveInfo{
+		whisper.NewArchiveInfo(60, 180), whisper.NewArchiveInfo(3600, 48)},
+		whisper.CreateOptions{AggregationMethod: whisper.AggregationAverage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []whisper.Point
+	for ts := now.Add(-47 * time.Hour); ts.Before(now); ts = ts.Add(time.Minute) {
+		points = append(points, whisper.Point{Timestamp: uint32(ts.Unix()),
+			Value: 1})
+	}
+	if err := w.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	windows := []ShardWindow{
+		{from: now.Add(-24 * time.Hour), until: now.Add(-2 * time.Hour)},
+		{from: now.Add(-2 * time.Hour), until: now},
+	}
+	for _, shardBatch := range []int{1, 2} {
+		migrationData := &MigrationData{archiveMode: ArchivesFetch,
+			downsample: 300, mtfs: map[string]*mapping.MTF{
+				wspFile: {Measurement: "day", Field: "value"}}}
+		var tsmPoints []TsmPoint
+		for start := 0; start < len(windows); start = start + shardBatch {
+			batch := migrationData.MapWSPToTSMByWhisperFile(wspFile,
+				windows[start:start+shardBatch])
+			for i := 0; i < shardBatch; i++ {
+				if len(batch[i]) != 1 {
+					t.Fatalf("batch of %d, window %d: %d series, expected 1",
+						shardBatch, start+i, len(batch[i]))
+				}
+				tsmPoints = append(tsmPoints, batch[i][0])
+			}
+		}
+		//The old window starts in the 1h archive only
+		for i, step := range []time.Duration{time.Hour, 5 * time.Minute} {
+			values := tsmPoints[i].values
+			if gap := values[1].Time().Sub(values[0].Time()); gap != step {
+				t.Errorf("batch of %d, window %d: step %v, expected %v",
+					shardBatch, i, gap, step)
+			}
+		}
+		//The recent window is downsampled from the 1m archive
+		recent := tsmPoints[1].values
+		if first := recent[0].Time(); !first.Equal(windows[1].from) {
+			t.Errorf("batch of %d: first recent point %v, expected %v",
+				shardBatch, first, windows[1].from)
+		}
+		if len(recent) != 24 {
+			t.Errorf("batch of %d: %d recent points, expected 24", shardBatch,
+				len(recent))
+		}
+	}
+}
+
+// A shorter config written over the -tagconfig file leaves no trailing bytes
+func TestWriteConfigFileTruncates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphite-influx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tagconfig.json")
+
+	long := mapping.TagConfig{Pattern: "carbon.agents.#TEXT1.#TEXT2",
+		Measurement: "#TEXT2", Field: "value", Tags: []mapping.TagKeyValue{
+			{Tagkey: "host", Tagvalue: "#TEXT1"}}}
+	short := mapping.TagConfig{Pattern: "a.#TEXT1", Field: "value"}
+	for _, tagConfig := range []mapping.TagConfig{long, short} {
+		mapper, err := mapping.NewMapper([]mapping.TagConfig{tagConfig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrationData := &MigrationData{mapper: mapper}
+		migrationData.WriteConfigFile(filename)
+	}
+
+	migrationData := &MigrationData{}
+	migrationData.ReadTagConfig(filename)
+	tagConfigs := migrationData.mapper.TagConfigs()
+	if len(tagConfigs) != 1 || tagConfigs[0].Pattern != short.Pattern {
+		t.Errorf("read %+v, expected %+v", tagConfigs, short)
+	}
+}
@@ -0,0 +1,178 @@
+package migration
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uttamgandhi/graphite-influx/mapping"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// Plan of a migration as reported by -dry-run. It is built from the whisper
+// headers only, neither InfluxDB nor the data directory is touched
+type Plan struct {
+	ShardDuration string              `json:"shardDuration"`
+	ArchiveMode   string              `json:"archiveMode"`
+	Files         []PlanFile          `json:"files"`
+	Unmatched     []string            `json:"unmatched"`
+	Collisions    map[string][]string `json:"collisions"`
+}
+
+type PlanFile struct {
+	WspFile           string        `json:"wspFile"`
+	MetricName        string        `json:"metricName"`
+	Pattern           string        `json:"pattern"`
+	SeriesKey         string        `json:"seriesKey"`
+	AggregationMethod string        `json:"aggregationMethod"`
+	XFilesFactor      float32       `json:"xFilesFactor"`
+	Archives          []PlanArchive `json:"archives"`
+	Shards            []PlanShard   `json:"shards"`
+	Error             string        `json:"error,omitempty"`
+}
+
+type PlanArchive struct {
+	SecondsPerPoint uint32 `json:"secondsPerPoint"`
+	Points          uint32 `json:"points"`
+	Retention       string `json:"retention"`
+}
+
+type PlanShard struct {
+	From            time.Time `json:"from"`
+	Until           time.Time `json:"until"`
+	EstimatedPoints int64     `json:"estimatedPoints"`
+}
+
+// Build the migration plan for shard groups of the given duration
+func (migrationData *MigrationData) BuildPlan(shardDuration time.Duration) *Plan {
+	plan := &Plan{ShardDuration: shardDuration.String(),
+		ArchiveMode: migrationData.archiveMode,
+		Collisions:  make(map[string][]string)}
+	migrationData.shards = migrationData.PlanShardGroups(shardDuration)
+	windows := migrationData.ShardWindows()
+	now := time.Now()
+
+	//Collisions are found by the mapped series keys like ResolveCollisions
+	var matched []string
+	mtfs := make(map[string]*mapping.MTF)
+	patterns := make(map[string]string)
+	keyFiles := make(map[string][]string)
+	for _, wspFile := range migrationData.wspFiles {
+		mtf, pattern := migrationData.MatchMTF(wspFile)
+		if mtf == nil {
+			plan.Unmatched = append(plan.Unmatched, wspFile)
+			continue
+		}
+		matched = append(matched, wspFile)
+		mtfs[wspFile] = mtf
+		patterns[wspFile] = pattern
+		key := mapping.CreateTSMKey(mtf)
+		keyFiles[key] = append(keyFiles[key], wspFile)
+	}
+	for key, files := range keyFiles {
+		if len(files) > 1 {
+			sort.Strings(files)
+			plan.Collisions[key] = files
+		}
+	}
+
+	for _, wspFile := range matched {
+		mtf := mtfs[wspFile]
+		if migrationData.onCollision == CollisionTag &&
+			len(keyFiles[mapping.CreateTSMKey(mtf)]) > 1 {
+			mtf = migrationData.CollisionMTF(mtf, wspFile)
+		}
+		planFile := PlanFile{WspFile: wspFile,
+			MetricName: migrationData.MetricName(wspFile),
+			Pattern:    patterns[wspFile], SeriesKey: mapping.CreateTSMKey(mtf)}
+
+		w, err := whisper.Open(wspFile)
+		if err != nil {
+			planFile.Error = err.Error()
+			plan.Files = append(plan.Files, planFile)
+			continue
+		}
+		header := w.Header
+		w.Close()
+
+		mtf = migrationData.AggregationMTF(mtf, header.Metadata.AggregationMethod)
+		planFile.SeriesKey = mapping.CreateTSMKey(mtf)
+		planFile.AggregationMethod = header.Metadata.AggregationMethod.String()
+		planFile.XFilesFactor = header.Metadata.XFilesFactor
+		for _, archive := range header.Archives {
+			planFile.Archives = append(planFile.Archives, PlanArchive{
+				SecondsPerPoint: archive.SecondsPerPoint, Points: archive.Points,
+				Retention: (time.Duration(archive.Retention()) * time.Second).String()})
+		}
+		for _, window := range windows {
+			estimate := EstimatePoints(header.Archives, migrationData.ReadMode(),
+				migrationData.downsample, window.from, window.until, now)
+			if estimate > 0 {
+				planFile.Shards = append(planFile.Shards, PlanShard{
+					From: window.from, Until: window.until, EstimatedPoints: estimate})
+			}
+		}
+		plan.Files = append(plan.Files, planFile)
+	}
+	return plan
+}
+
+// Write the plan as indented JSON
+func (plan *Plan) WriteJSON(w io.Writer) error {
+	raw, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(raw))
+	return err
+}
+
+// Write the plan as CSV, one row per whisper file and shard. Unmatched files
+// and files without points in any shard have a single row
+func (plan *Plan) WriteCSV(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write([]string{"status", "whisper_file", "metric_name", "pattern",
+		"series_key", "aggregation_method", "x_files_factor", "archives",
+		"shard_from", "shard_until", "estimated_points"})
+
+	colliding := make(map[string]bool)
+	for _, files := range plan.Collisions {
+		for _, wspFile := range files {
+			colliding[wspFile] = true
+		}
+	}
+	for _, planFile := range plan.Files {
+		status := "matched"
+		if colliding[planFile.WspFile] {
+			status = "collision"
+		}
+		if planFile.Error != "" {
+			status = "error: " + planFile.Error
+		}
+		archives := make([]string, len(planFile.Archives))
+		for i, archive := range planFile.Archives {
+			archives[i] = fmt.Sprintf("%ds:%s", archive.SecondsPerPoint,
+				archive.Retention)
+		}
+		row := []string{status, planFile.WspFile, planFile.MetricName,
+			planFile.Pattern, planFile.SeriesKey, planFile.AggregationMethod,
+			fmt.Sprint(planFile.XFilesFactor), strings.Join(archives, " ")}
+		if len(planFile.Shards) == 0 {
+			csvWriter.Write(append(row, "", "", "0"))
+		}
+		for _, shard := range planFile.Shards {
+			csvWriter.Write(append(row, shard.From.Format(time.RFC3339),
+				shard.Until.Format(time.RFC3339), fmt.Sprint(shard.EstimatedPoints)))
+		}
+	}
+	for _, wspFile := range plan.Unmatched {
+		csvWriter.Write([]string{"unmatched", wspFile, "", "", "", "", "", "", "",
+			"", ""})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
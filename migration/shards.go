@@ -0,0 +1,426 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Measurement written to create the shard groups, it is dropped afterwards
+const shardMeasurement = "graphite_influx_shard"
+
+/*
+
+ Create shards for given time range, shards should be created before the tsm
+ data can be written
+ The shard group boundaries are computed from the shard group duration of the
+ retention policy the same way InfluxDB does. For every shard group that does
+ not exist yet a single point is written, then the measurement is dropped.
+ The shards remain even if the measurement is dropped. With dryRun the
+ missing shard groups are only listed
+*/
+
+func (migrationData *MigrationData) CreateShards(dryRun bool) error {
+	c, err := migrationData.influxConfig.NewClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	shardGroupDuration, rpExists, err := migrationData.ShardGroupDuration(c)
+	if err != nil {
+		return err
+	}
+	var existing []ShardInfo
+	if rpExists {
+		if existing, err = migrationData.ShowShardGroups(c); err != nil {
+			return err
+		}
+	}
+
+	var missing []ShardInfo
+	for _, shard := range migrationData.PlanShardGroups(shardGroupDuration) {
+		found := false
+		for _, existingShard := range existing {
+			if existingShard.from.Equal(shard.from) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, shard)
+		}
+	}
+
+	if dryRun {
+		fmt.Println("Shard group duration", shardGroupDuration)
+		for _, shard := range missing {
+			fmt.Println("Shard group to create", shard.from.Format(time.RFC3339),
+				shard.until.Format(time.RFC3339))
+		}
+		fmt.Println(len(missing), "shard groups to create,", len(existing),
+			"existing")
+		return nil
+	}
+
+	if err = migrationData.CreateRetentionPolicy(c, rpExists); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		if err = migrationData.WriteShardPoints(c, missing); err != nil {
+			return err
+		}
+		if existing, err = migrationData.ShowShardGroups(c); err != nil {
+			return err
+		}
+	}
+
+	//Keep the shard groups overlapping the migrated time range
+	migrationData.shards = nil
+	for _, shard := range existing {
+		if shard.until.After(migrationData.from) &&
+			shard.from.Before(migrationData.until) {
+			migrationData.shards = append(migrationData.shards, shard)
+		}
+	}
+	return migrationData.LookupShards(c)
+}
+
+// Get the shard group duration of the retention policy and whether the
+// retention policy exists. A policy which does not exist yet will be created
+// with an infinite duration
+func (migrationData *MigrationData) ShardGroupDuration(c client.Client) (time.Duration,
+	bool, error) {
+
+	query := fmt.Sprintf("SHOW RETENTION POLICIES ON %q", migrationData.dbName)
+	response, err := c.Query(client.NewQuery(query, "", ""))
+	if err != nil {
+		return 0, false, err
+	}
+	rows, err := ResponseRows(response)
+	if err != nil && strings.Contains(err.Error(), "database not found") {
+		//The database does not exist yet
+		return defaultShardGroupDuration(0), false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("show retention policies: %v", err)
+	}
+	for _, row := range rows {
+		if name, _ := row.String("name"); name != migrationData.rpName {
+			continue
+		}
+		str, _ := row.String("shardGroupDuration")
+		if d, err := time.ParseDuration(str); err == nil && d > 0 {
+			return d, true, nil
+		}
+		str, _ = row.String("duration")
+		duration, _ := time.ParseDuration(str)
+		return defaultShardGroupDuration(duration), true, nil
+	}
+	return defaultShardGroupDuration(0), false, nil
+}
+
+// Shard group duration InfluxDB uses for a retention policy duration
+func defaultShardGroupDuration(duration time.Duration) time.Duration {
+	if duration >= 180*24*time.Hour || duration == 0 {
+		return 7 * 24 * time.Hour
+	} else if duration >= 2*24*time.Hour {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// Get the shard groups needed for the migrated time range, InfluxDB starts a
+// shard group at a timestamp truncated to the shard group duration
+func (migrationData *MigrationData) PlanShardGroups(shardGroupDuration time.Duration) []ShardInfo {
+	var shards []ShardInfo
+	from := migrationData.from.Truncate(shardGroupDuration).UTC()
+	for ; from.Before(migrationData.until); from = from.Add(shardGroupDuration) {
+		shards = append(shards, ShardInfo{retentionPolicy: migrationData.rpName,
+			from: from, until: from.Add(shardGroupDuration)})
+	}
+	return shards
+}
+
+// Plan the shard groups of the migrated time range without InfluxDB, for
+// output imported later. The planned shard groups are numbered by their start
+// time
+func (migrationData *MigrationData) PlanShards(shardGroupDuration time.Duration) {
+	migrationData.shards = migrationData.PlanShardGroups(shardGroupDuration)
+	for i := range migrationData.shards {
+		shard := &migrationData.shards[i]
+		shard.id = json.Number(strconv.FormatInt(shard.from.Unix(), 10))
+	}
+}
+
+// Create the database and the retention policy if they do not exist
+func (migrationData *MigrationData) CreateRetentionPolicy(c client.Client,
+	rpExists bool) error {
+
+	query := fmt.Sprintf("CREATE DATABASE %q", migrationData.dbName)
+	response, err := c.Query(client.NewQuery(query, "", ""))
+	if err != nil {
+		return err
+	}
+	if err = response.Error(); err != nil {
+		return err
+	}
+	if rpExists {
+		return nil
+	}
+
+	query = fmt.Sprintf("CREATE RETENTION POLICY %q ON %q DURATION INF REPLICATION 1",
+		migrationData.rpName, migrationData.dbName)
+	response, err = c.Query(client.NewQuery(query, "", ""))
+	if err != nil {
+		return err
+	}
+	return response.Error()
+}
+
+// Write one point at the start of every shard group to create them, then drop
+// the measurement of the points
+func (migrationData *MigrationData) WriteShardPoints(c client.Client,
+	shards []ShardInfo) error {
+
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:        migrationData.dbName,
+		RetentionPolicy: migrationData.rpName,
+		Precision:       "s",
+	})
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{"value": 0.0}
+	for _, shard := range shards {
+		pt, err := client.NewPoint(shardMeasurement, nil, fields, shard.from)
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(pt)
+	}
+	if err = c.Write(bp); err != nil {
+		return fmt.Errorf("write shard points: %v", err)
+	}
+	log.Println("Created", len(shards), "shard groups")
+
+	//Once shards are created, this measurement is not required
+	query := fmt.Sprintf("DROP MEASUREMENT %q", shardMeasurement)
+	response, err := c.Query(client.NewQuery(query, migrationData.dbName, ""))
+	if err != nil {
+		return err
+	}
+	return response.Error()
+}
+
+// Get the shard groups of the database and retention policy
+func (migrationData *MigrationData) ShowShardGroups(c client.Client) ([]ShardInfo, error) {
+	response, err := c.Query(client.NewQuery("SHOW SHARD GROUPS", "", ""))
+	if err != nil {
+		return nil, err
+	}
+	return ParseShardGroups(response, migrationData.dbName,
+		migrationData.rpName)
+}
+
+// Parse the SHOW SHARD GROUPS response by column name into the shard groups
+// of a database and retention policy. No shard groups is not an error
+func ParseShardGroups(response *client.Response, database string,
+	retentionPolicy string) ([]ShardInfo, error) {
+
+	rows, err := ResponseRows(response)
+	if err != nil {
+		return nil, fmt.Errorf("show shard groups: %v", err)
+	}
+	var shards []ShardInfo
+	for _, row := range rows {
+		rowDatabase, err := row.String("database")
+		if err != nil {
+			return nil, fmt.Errorf("show shard groups: %v", err)
+		}
+		if rowDatabase != database {
+			continue
+		}
+		//Older versions have no retention_policy column
+		if row.Has("retention_policy") {
+			rowRetentionPolicy, err := row.String("retention_policy")
+			if err != nil {
+				return nil, fmt.Errorf("show shard groups: %v", err)
+			}
+			if rowRetentionPolicy != retentionPolicy {
+				continue
+			}
+		}
+
+		shard := ShardInfo{retentionPolicy: retentionPolicy}
+		if shard.id, err = row.Number("id"); err != nil {
+			return nil, fmt.Errorf("show shard groups: %v", err)
+		}
+		if shard.from, err = row.Time("start_time"); err != nil {
+			return nil, fmt.Errorf("show shard groups: %v", err)
+		}
+		if shard.until, err = row.Time("end_time"); err != nil {
+			return nil, fmt.Errorf("show shard groups: %v", err)
+		}
+		shards = append(shards, shard)
+	}
+	return shards, nil
+}
+
+// Look up the shard of every shard group with SHOW SHARDS, the shard id names
+// the directory of the shard in the data directory
+func (migrationData *MigrationData) LookupShards(c client.Client) error {
+	response, err := c.Query(client.NewQuery("SHOW SHARDS", "", ""))
+	if err != nil {
+		return err
+	}
+	return ParseShards(response, migrationData.dbName, migrationData.shards)
+}
+
+// Parse the SHOW SHARDS response by column name and set the shard id of the
+// shard groups. Shard groups are matched by the shard_group column, or by
+// start time on older versions without it
+func ParseShards(response *client.Response, database string,
+	shards []ShardInfo) error {
+
+	rows, err := ResponseRows(response)
+	if err != nil {
+		return fmt.Errorf("show shards: %v", err)
+	}
+	for i := range shards {
+		shard := &shards[i]
+		for _, row := range rows {
+			//Older versions name the series after the database
+			rowDatabase := row.series
+			if row.Has("database") {
+				if rowDatabase, err = row.String("database"); err != nil {
+					return fmt.Errorf("show shards: %v", err)
+				}
+			}
+			if rowDatabase != database {
+				continue
+			}
+			if row.Has("retention_policy") {
+				rowRetentionPolicy, err := row.String("retention_policy")
+				if err != nil {
+					return fmt.Errorf("show shards: %v", err)
+				}
+				if rowRetentionPolicy != shard.retentionPolicy {
+					continue
+				}
+			}
+			if row.Has("shard_group") {
+				group, err := row.Number("shard_group")
+				if err != nil {
+					return fmt.Errorf("show shards: %v", err)
+				}
+				if group != shard.id {
+					continue
+				}
+			} else {
+				start, err := row.Time("start_time")
+				if err != nil {
+					return fmt.Errorf("show shards: %v", err)
+				}
+				if !start.Equal(shard.from) {
+					continue
+				}
+			}
+			if shard.shardID, err = row.Number("id"); err != nil {
+				return fmt.Errorf("show shards: %v", err)
+			}
+		}
+		if shard.shardID == "" {
+			return fmt.Errorf("no shard found for shard group %v", shard.id)
+		}
+	}
+	return nil
+}
+
+// A row of a query response by column name
+type ResponseRow struct {
+	series string
+	values map[string]interface{}
+}
+
+// Get the rows of all series of a query response, a response without series
+// has no rows
+func ResponseRows(response *client.Response) ([]ResponseRow, error) {
+	if response == nil {
+		return nil, fmt.Errorf("empty response")
+	}
+	if err := response.Error(); err != nil {
+		return nil, err
+	}
+	var rows []ResponseRow
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			for _, values := range series.Values {
+				if len(values) != len(series.Columns) {
+					return nil, fmt.Errorf("%d values for %d columns", len(values),
+						len(series.Columns))
+				}
+				row := ResponseRow{series: series.Name,
+					values: make(map[string]interface{}, len(values))}
+				for i, column := range series.Columns {
+					row.values[column] = values[i]
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
+
+func (row ResponseRow) Has(column string) bool {
+	_, ok := row.values[column]
+	return ok
+}
+
+func (row ResponseRow) String(column string) (string, error) {
+	value, ok := row.values[column]
+	if !ok {
+		return "", fmt.Errorf("column %q missing", column)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("column %q is %T, not a string", column, value)
+	}
+	return str, nil
+}
+
+// Get a number column, the client decodes numbers as json.Number or float64
+func (row ResponseRow) Number(column string) (json.Number, error) {
+	value, ok := row.values[column]
+	if !ok {
+		return "", fmt.Errorf("column %q missing", column)
+	}
+	switch number := value.(type) {
+	case json.Number:
+		return number, nil
+	case float64:
+		return json.Number(strconv.FormatFloat(number, 'f', -1, 64)), nil
+	case string:
+		if _, err := strconv.ParseInt(number, 10, 64); err == nil {
+			return json.Number(number), nil
+		}
+	}
+	return "", fmt.Errorf("column %q is %v, not a number", column, value)
+}
+
+func (row ResponseRow) Time(column string) (time.Time, error) {
+	str, err := row.String(column)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("column %q: %v", column, err)
+	}
+	return t, nil
+}
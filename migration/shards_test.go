@@ -0,0 +1,311 @@
+package migration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+	"github.com/influxdb/influxdb/models"
+)
+
+// Query a server answering with a recorded response body and status
+func recordedQuery(t *testing.T, query string, status int,
+	body string) (*client.Response, error) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		if q := r.URL.Query().Get("q"); q != query {
+			t.Errorf("unexpected query %q", q)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	c, err := client.NewHTTPClient(client.HTTPConfig{Addr: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.Query(client.NewQuery(query, "", ""))
+}
+
+func TestParseShardGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		ids    []string
+		err    string
+	}{
+		{
+			name:   "no series",
+			status: http.StatusOK,
+			body:   `{"results":[{}]}`,
+		},
+		{
+			name:   "filtered by database and retention policy",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[{"name":"shard groups",
+				"columns":["id","database","retention_policy","start_time","end_time","expiry_time"],
+				"values":[
+					[1,"migrated","default","2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z"],
+					[2,"migrated","weekly","2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z"],
+					[3,"_internal","default","2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z"],
+					[4,"migrated","default","2015-11-02T00:00:00Z","2015-11-09T00:00:00Z","2015-11-09T00:00:00Z"]]}]}]}`,
+			ids: []string{"1", "4"},
+		},
+		{
+			name:   "no retention_policy column",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[{"name":"shard groups",
+				"columns":["id","database","start_time","end_time","expiry_time"],
+				"values":[
+					[7,"migrated","2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z"],
+					[8,"_internal","2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z"]]}]}]}`,
+			ids: []string{"7"},
+		},
+		{
+			name:   "error body",
+			status: http.StatusBadRequest,
+			body:   `{"error":"error parsing query: found SHARD"}`,
+			err:    "error parsing query",
+		},
+		{
+			name:   "result error",
+			status: http.StatusOK,
+			body:   `{"results":[{"error":"not authorized"}]}`,
+			err:    "not authorized",
+		},
+		{
+			name:   "values columns mismatch",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[{"name":"shard groups",
+				"columns":["id","database","retention_policy","start_time","end_time"],
+				"values":[[1,"migrated","default","2015-10-26T00:00:00Z"]]}]}]}`,
+			err: "4 values for 5 columns",
+		},
+		{
+			name:   "missing start_time column",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[{"name":"shard groups",
+				"columns":["id","database","retention_policy","end_time"],
+				"values":[[1,"migrated","default","2015-11-02T00:00:00Z"]]}]}]}`,
+			err: `column "start_time" missing`,
+		},
+	}
+	for _, test := range tests {
+		response, err := recordedQuery(t, "SHOW SHARD GROUPS", test.status,
+			test.body)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		shards, err := ParseShardGroups(response, "migrated", "default")
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var ids []string
+		for _, shard := range shards {
+			ids = append(ids, shard.id.String())
+			if shard.retentionPolicy != "default" || !shard.until.After(shard.from) {
+				t.Errorf("%s: unexpected shard group %+v", test.name, shard)
+			}
+		}
+		if strings.Join(ids, ",") != strings.Join(test.ids, ",") {
+			t.Errorf("%s: shard groups %v, expected %v", test.name, ids, test.ids)
+		}
+	}
+}
+
+func TestParseShards(t *testing.T) {
+	from := time.Date(2015, 10, 26, 0, 0, 0, 0, time.UTC)
+	newShards := func() []ShardInfo {
+		return []ShardInfo{
+			{id: "1", retentionPolicy: "default", from: from,
+				until: from.Add(7 * 24 * time.Hour)},
+			{id: "4", retentionPolicy: "default", from: from.Add(7 * 24 * time.Hour),
+				until: from.Add(14 * 24 * time.Hour)},
+		}
+	}
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		shardIDs []string
+		err      string
+	}{
+		{
+			name:   "shard_group column",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[{"name":"migrated",
+				"columns":["id","database","retention_policy","shard_group","start_time","end_time","expiry_time","owners"],
+				"values":[
+					[3,"migrated","default",1,"2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z","1"],
+					[5,"migrated","weekly",4,"2015-11-02T00:00:00Z","2015-11-09T00:00:00Z","2015-11-09T00:00:00Z","1"],
+					[6,"migrated","default",4,"2015-11-02T00:00:00Z","2015-11-09T00:00:00Z","2015-11-09T00:00:00Z","1"]]}]}]}`,
+			shardIDs: []string{"3", "6"},
+		},
+		{
+			name:   "no shard_group and retention_policy columns",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[
+				{"name":"_internal","columns":["id","start_time","end_time","expiry_time","owners"],
+				"values":[[2,"2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z","1"]]},
+				{"name":"migrated","columns":["id","start_time","end_time","expiry_time","owners"],
+				"values":[
+					[8,"2015-10-26T00:00:00Z","2015-11-02T00:00:00Z","2015-11-02T00:00:00Z","1"],
+					[9,"2015-11-02T00:00:00Z","2015-11-09T00:00:00Z","2015-11-09T00:00:00Z","1"]]}]}]}`,
+			shardIDs: []string{"8", "9"},
+		},
+		{
+			name:   "no series",
+			status: http.StatusOK,
+			body:   `{"results":[{}]}`,
+			err:    "no shard found for shard group 1",
+		},
+		{
+			name:   "error body",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"authorization failed"}`,
+			err:    "authorization failed",
+		},
+		{
+			name:   "values columns mismatch",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[{"name":"migrated",
+				"columns":["id","database","retention_policy","shard_group"],
+				"values":[[3,"migrated","default",1,"2015-10-26T00:00:00Z"]]}]}]}`,
+			err: "5 values for 4 columns",
+		},
+	}
+	for _, test := range tests {
+		response, err := recordedQuery(t, "SHOW SHARDS", test.status, test.body)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		shards := newShards()
+		err = ParseShards(response, "migrated", shards)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		for i, shard := range shards {
+			if shard.shardID.String() != test.shardIDs[i] {
+				t.Errorf("%s: shard %v of shard group %v, expected %v", test.name,
+					shard.shardID, shard.id, test.shardIDs[i])
+			}
+		}
+	}
+}
+
+// The client decodes numbers as json.Number, a response decoded without
+// UseNumber has float64
+func TestResponseRowNumber(t *testing.T) {
+	response := &client.Response{Results: []client.Result{{Series: []models.Row{{
+		Name:    "shard groups",
+		Columns: []string{"id", "database", "retention_policy", "start_time", "end_time"},
+		Values: [][]interface{}{
+			{float64(12), "migrated", "default", "2015-10-26T00:00:00Z",
+				"2015-11-02T00:00:00Z"},
+			{json.Number("13"), "migrated", "default", "2015-11-02T00:00:00Z",
+				"2015-11-09T00:00:00Z"},
+			{"x", "migrated", "default", "2015-11-09T00:00:00Z",
+				"2015-11-16T00:00:00Z"},
+		},
+	}}}}}
+	rows, err := ResponseRows(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []string{"12", "13"} {
+		id, err := rows[i].Number("id")
+		if err != nil || id.String() != expected {
+			t.Errorf("id %v %v, expected %v", id, err, expected)
+		}
+	}
+	if _, err := rows[2].Number("id"); err == nil {
+		t.Error("expected an error for a string id")
+	}
+
+	_, err = ParseShardGroups(response, "migrated", "default")
+	if err == nil || !strings.Contains(err.Error(), `column "id"`) {
+		t.Errorf("error %v, expected a column \"id\" error", err)
+	}
+}
+
+func TestShardGroupDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		duration time.Duration
+		exists   bool
+		err      string
+	}{
+		{
+			name:     "database not found",
+			status:   http.StatusOK,
+			body:     `{"results":[{"error":"database not found: migrated"}]}`,
+			duration: 7 * 24 * time.Hour,
+		},
+		{
+			name:   "other error",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"authorization failed"}`,
+			err:    "authorization failed",
+		},
+		{
+			name:   "retention policy",
+			status: http.StatusOK,
+			body: `{"results":[{"series":[{"columns":["name","duration","replicaN","default"],
+				"values":[["default","0",1,true],["weekly","168h0m0s",1,false]]}]}]}`,
+			duration: 24 * time.Hour,
+			exists:   true,
+		},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		c, err := client.NewHTTPClient(client.HTTPConfig{Addr: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrationData := &MigrationData{dbName: "migrated", rpName: "weekly"}
+		duration, exists, err := migrationData.ShardGroupDuration(c)
+		server.Close()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || duration != test.duration || exists != test.exists {
+			t.Errorf("%s: %v %v %v, expected %v %v", test.name, duration, exists,
+				err, test.duration, test.exists)
+		}
+	}
+}
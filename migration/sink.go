@@ -0,0 +1,94 @@
+package migration
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Sink is the output of the migration. One Sink is created per shard, the
+// series of the shard are written between Open and Close
+type Sink interface {
+	Open() error
+	WriteSeries(tsmPoint TsmPoint) error
+	Close() error
+}
+
+// A Sink which keeps the series it wrote when its shard is written again, so
+// a -resume run only writes the whisper files not recorded in the journal.
+// Written is the number of points durably written so far
+type ResumableSink interface {
+	Sink
+	Written() int64
+}
+
+// Creates the Sink of a shard
+type SinkFactory func(shard ShardInfo) Sink
+
+// Points of a series, one per value
+func NewPoints(tsmPoint TsmPoint) ([]*client.Point, error) {
+	tags := make(map[string]string)
+	for _, tagKeyValue := range tsmPoint.mtf.Tags {
+		tags[tagKeyValue.Tagkey] = tagKeyValue.Tagvalue
+	}
+	points := make([]*client.Point, len(tsmPoint.values))
+	for i, value := range tsmPoint.values {
+		fields := map[string]interface{}{tsmPoint.mtf.Field: value.Value()}
+		pt, err := client.NewPoint(tsmPoint.mtf.Measurement, tags, fields,
+			time.Unix(0, value.UnixNano()))
+		if err != nil {
+			return nil, fmt.Errorf("create point for %v: %v", tsmPoint.key, err)
+		}
+		points[i] = pt
+	}
+	return points, nil
+}
+
+// Lines of line protocol of a series with nanosecond timestamps, one per
+// value, the default precision of influx -import
+func LineProtocol(tsmPoint TsmPoint) ([]string, error) {
+	points, err := NewPoints(tsmPoint)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, len(points))
+	for i, pt := range points {
+		lines[i] = pt.String()
+	}
+	return lines, nil
+}
+
+// StdoutSink prints the series as line protocol, the shards are written in
+// parallel so every series is printed under a lock
+type StdoutSink struct{}
+
+var stdoutLock sync.Mutex
+
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{}
+}
+
+func (sink *StdoutSink) Open() error {
+	return nil
+}
+
+func (sink *StdoutSink) WriteSeries(tsmPoint TsmPoint) error {
+	lines, err := LineProtocol(tsmPoint)
+	if err != nil {
+		return err
+	}
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	for _, line := range lines {
+		if _, err := fmt.Println(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sink *StdoutSink) Close() error {
+	return nil
+}
@@ -0,0 +1,282 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+)
+
+// TSMSink writes the series of a shard to a new TSM file in the shard's
+// directory. The TSM writer needs all the keys of the file at once, so the
+// series are collected and written on Close
+type TSMSink struct {
+	shardDir  string
+	tsmPoints []TsmPoint
+	filename  string
+	written   int64
+}
+
+func NewTSMSink(shardDir string) *TSMSink {
+	return &TSMSink{shardDir: shardDir}
+}
+
+func (sink *TSMSink) Open() error {
+	return nil
+}
+
+func (sink *TSMSink) WriteSeries(tsmPoint TsmPoint) error {
+	sink.tsmPoints = append(sink.tsmPoints, tsmPoint)
+	return nil
+}
+
+func (sink *TSMSink) Close() error {
+	if len(sink.tsmPoints) == 0 {
+		return nil
+	}
+	filename, err := NextTSMFileName(sink.shardDir)
+	if err != nil {
+		return err
+	}
+	if err := WriteTSMPoints(filename, sink.tsmPoints); err != nil {
+		return err
+	}
+	sink.filename = filename
+	for _, tsmPoint := range sink.tsmPoints {
+		sink.written = sink.written + int64(len(tsmPoint.values))
+	}
+	return nil
+}
+
+// Number of points written, none before the TSM file is written on Close.
+// A shard written again gets a TSM file of the next generation, the files
+// of a shard are merged by tsm1
+func (sink *TSMSink) Written() int64 {
+	return sink.written
+}
+
+// Read the written TSM file back and compare it with the expected series. A
+// TSM file failing verification is removed from the shard directory
+func (sink *TSMSink) Verify(expected map[string]SeriesSummary) *ShardVerification {
+	if len(expected) == 0 && sink.filename == "" {
+		return &ShardVerification{}
+	}
+	if sink.filename == "" {
+		return &ShardVerification{Series: len(expected),
+			Mismatches: []string{"no TSM file written"}}
+	}
+	verification := VerifyTSMFile(sink.filename, expected)
+	if verification.Passed() {
+		return verification
+	}
+	if err := os.Remove(sink.filename); err != nil {
+		verification.Mismatches = append(verification.Mismatches,
+			fmt.Sprintf("remove TSM file: %v", err))
+	} else {
+		log.Println("Removed TSM file", sink.filename, "failing verification")
+	}
+	return verification
+}
+
+const (
+	tsmFileExtension = "tsm"
+	tmpFileExtension = "tmp"
+)
+
+var errNoSeries = errors.New("no series with values")
+
+// Get the path of a new TSM file in a shard directory. tsm1 names the files
+// <generation>-<sequence>.tsm, the new file gets the generation after the
+// highest one in the directory, including the temporary files of compactions
+func NextTSMFileName(shardDir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(shardDir, "*."+tsmFileExtension+"*"))
+	if err != nil {
+		return "", err
+	}
+	generation := 0
+	for _, file := range files {
+		fileGeneration, _, err := ParseTSMFileName(file)
+		if err != nil {
+			continue
+		}
+		if fileGeneration > generation {
+			generation = fileGeneration
+		}
+	}
+	return filepath.Join(shardDir, fmt.Sprintf("%09d-%09d.%s", generation+1, 1,
+		tsmFileExtension)), nil
+}
+
+// Get the generation and sequence of a TSM file name
+func ParseTSMFileName(name string) (int, int, error) {
+	base := filepath.Base(name)
+	parts := strings.Split(strings.SplitN(base, ".", 2)[0], "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid TSM file name: %v", name)
+	}
+	generation, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid TSM file generation: %v", name)
+	}
+	sequence, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid TSM file sequence: %v", name)
+	}
+	return generation, sequence, nil
+}
+
+// Write TSMPoints data to a TSM file. The data is written to a temporary file
+// which is synced and renamed to filename, so a shard never has a partial TSM
+// file
+func WriteTSMPoints(filename string, tsmPoints []TsmPoint) error {
+	if len(tsmPoints) == 0 {
+		return nil
+	}
+	tmpFilename := filename + "." + tmpFileExtension
+	if err := writeTSMFile(tmpFilename, tsmPoints); err != nil {
+		os.Remove(tmpFilename)
+		if err == errNoSeries {
+			return nil
+		}
+		return err
+	}
+	if err := syncFile(tmpFilename); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("sync TSM file: %v", err)
+	}
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("rename TSM file: %v", err)
+	}
+	return syncFile(filepath.Dir(filename))
+}
+
+// fsync a file or directory by name, the TSM writer closes the file it writes
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Sort the series by key and their values by time, as the TSM writer needs
+// them. Series with the same key are combined and of values with the same
+// timestamp the one written last is kept
+func SortTsmPoints(tsmPoints []TsmPoint) []TsmPoint {
+	sorted := make([]TsmPoint, len(tsmPoints))
+	copy(sorted, tsmPoints)
+	sort.Stable(byKey(sorted))
+
+	var merged []TsmPoint
+	for _, tsmPoint := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && merged[last].key == tsmPoint.key {
+			merged[last].values = append(merged[last].values, tsmPoint.values...)
+			continue
+		}
+		tsmPoint.values = append([]tsm1.Value{}, tsmPoint.values...)
+		merged = append(merged, tsmPoint)
+	}
+	for i := range merged {
+		merged[i].values = SortValues(merged[i].values)
+	}
+	return merged
+}
+
+// Sort values by time and drop duplicate timestamps, the last value of a
+// timestamp wins
+func SortValues(values []tsm1.Value) []tsm1.Value {
+	sort.Stable(byTime(values))
+	deduped := values[:0]
+	for _, value := range values {
+		last := len(deduped) - 1
+		if last >= 0 && deduped[last].UnixNano() == value.UnixNano() {
+			deduped[last] = value
+			continue
+		}
+		deduped = append(deduped, value)
+	}
+	return deduped
+}
+
+// Check that keys are strictly increasing and the values of every key are
+// strictly increasing in time
+func CheckTsmPoints(tsmPoints []TsmPoint) error {
+	for i, tsmPoint := range tsmPoints {
+		if i > 0 && tsmPoints[i-1].key >= tsmPoint.key {
+			return fmt.Errorf("key %v not after %v", tsmPoint.key,
+				tsmPoints[i-1].key)
+		}
+		for j := 1; j < len(tsmPoint.values); j++ {
+			if tsmPoint.values[j-1].UnixNano() >= tsmPoint.values[j].UnixNano() {
+				return fmt.Errorf("values of %v not sorted by time at %v",
+					tsmPoint.key, tsmPoint.values[j].Time())
+			}
+		}
+	}
+	return nil
+}
+
+type byKey []TsmPoint
+
+func (a byKey) Len() int           { return len(a) }
+func (a byKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byKey) Less(i, j int) bool { return a[i].key < a[j].key }
+
+type byTime []tsm1.Value
+
+func (a byTime) Len() int           { return len(a) }
+func (a byTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTime) Less(i, j int) bool { return a[i].UnixNano() < a[j].UnixNano() }
+
+func writeTSMFile(filename string, tsmPoints []TsmPoint) error {
+	tsmPoints = SortTsmPoints(tsmPoints)
+	if err := CheckTsmPoints(tsmPoints); err != nil {
+		return fmt.Errorf("invalid TSM data: %v", err)
+	}
+
+	// Open tsm file for writing
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("open TSM file: %v", err)
+	}
+	defer f.Close()
+
+	//Create TSMWriter with filehandle
+	tsmWriter, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		return fmt.Errorf("create TSM writer: %v", err)
+	}
+
+	//Write the points in batch
+	writes := 0
+	for _, tsmPoint := range tsmPoints {
+		if len(tsmPoint.values) > 0 {
+			if err := tsmWriter.Write(tsmPoint.key, tsmPoint.values); err != nil {
+				return fmt.Errorf("write TSM value: %v", err)
+			}
+			writes = writes + 1
+		}
+	}
+	// Should not write index if there are no writes
+	if writes == 0 {
+		return errNoSeries
+	}
+	//Write index
+	if err := tsmWriter.WriteIndex(); err != nil {
+		return fmt.Errorf("write TSM index: %v", err)
+	}
+
+	if err := tsmWriter.Close(); err != nil {
+		return fmt.Errorf("write TSM close: %v", err)
+	}
+	return nil
+}
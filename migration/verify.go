@@ -0,0 +1,202 @@
+package migration
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+)
+
+// A Sink which can check what it has written against the summaries of the
+// series expected in the shard, after Close
+type VerifiableSink interface {
+	Sink
+	Verify(expected map[string]SeriesSummary) *ShardVerification
+}
+
+// Point count, time range and checksum of the values of a series
+type SeriesSummary struct {
+	Count    int
+	MinTime  int64
+	MaxTime  int64
+	Checksum uint64
+}
+
+// Summarize values sorted by time, the checksum is a FNV-1a hash of the
+// timestamps and values
+func SummarizeValues(values []tsm1.Value) SeriesSummary {
+	summary := SeriesSummary{Count: len(values)}
+	hash := fnv.New64a()
+	buf := make([]byte, 8)
+	for i, value := range values {
+		if i == 0 {
+			summary.MinTime = value.UnixNano()
+		}
+		summary.MaxTime = value.UnixNano()
+		binary.BigEndian.PutUint64(buf, uint64(value.UnixNano()))
+		hash.Write(buf)
+		switch v := value.Value().(type) {
+		case float64:
+			binary.BigEndian.PutUint64(buf, math.Float64bits(v))
+			hash.Write(buf)
+		case int64:
+			binary.BigEndian.PutUint64(buf, uint64(v))
+			hash.Write(buf)
+		default:
+			hash.Write([]byte(fmt.Sprint(v)))
+		}
+	}
+	summary.Checksum = hash.Sum64()
+	return summary
+}
+
+// Summaries of series by key, series with the same key are combined like
+// they are in a TSM file
+func SummarizeSeries(tsmPoints []TsmPoint) map[string]SeriesSummary {
+	summaries := make(map[string]SeriesSummary)
+	for _, tsmPoint := range SortTsmPoints(tsmPoints) {
+		if len(tsmPoint.values) > 0 {
+			summaries[tsmPoint.key] = SummarizeValues(tsmPoint.values)
+		}
+	}
+	return summaries
+}
+
+// Result of verifying the TSM file of a shard
+type ShardVerification struct {
+	Shard      ShardInfo
+	File       string
+	Series     int
+	Points     int
+	Mismatches []string
+}
+
+func (verification *ShardVerification) Passed() bool {
+	return len(verification.Mismatches) == 0
+}
+
+// Reopen a TSM file and compare every key with the summaries of the series
+// written to it
+func VerifyTSMFile(filename string,
+	expected map[string]SeriesSummary) *ShardVerification {
+
+	verification := &ShardVerification{File: filename, Series: len(expected)}
+	mismatch := func(format string, args ...interface{}) {
+		verification.Mismatches = append(verification.Mismatches,
+			fmt.Sprintf(format, args...))
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		mismatch("open TSM file: %v", err)
+		return verification
+	}
+	tsmReader, err := tsm1.NewTSMReaderWithOptions(
+		tsm1.TSMReaderOptions{
+			MMAPFile: f,
+		})
+	if err != nil {
+		f.Close()
+		mismatch("create TSM reader: %v", err)
+		return verification
+	}
+	defer tsmReader.Close()
+
+	keys := make(map[string]bool)
+	for _, key := range tsmReader.Keys() {
+		keys[key] = true
+		if _, ok := expected[key]; !ok {
+			mismatch("%v: unexpected key", key)
+		}
+	}
+	for key, want := range expected {
+		verification.Points = verification.Points + want.Count
+		if !keys[key] {
+			mismatch("%v: missing", key)
+			continue
+		}
+		values, err := tsmReader.ReadAll(key)
+		if err != nil {
+			mismatch("%v: read: %v", key, err)
+			continue
+		}
+		got := SummarizeValues(values)
+		if got.Count != want.Count {
+			mismatch("%v: %d points, expected %d", key, got.Count, want.Count)
+		}
+		if got.MinTime != want.MinTime || got.MaxTime != want.MaxTime {
+			mismatch("%v: time range %v - %v, expected %v - %v", key,
+				time.Unix(0, got.MinTime).UTC(), time.Unix(0, got.MaxTime).UTC(),
+				time.Unix(0, want.MinTime).UTC(), time.Unix(0, want.MaxTime).UTC())
+		}
+		if got.Checksum != want.Checksum {
+			mismatch("%v: checksum %x, expected %x", key, got.Checksum,
+				want.Checksum)
+		}
+	}
+	return verification
+}
+
+// VerifyReport is a CSV file with the pass or fail of every verified shard
+// and the mismatches of the failed ones
+type VerifyReport struct {
+	f      *os.File
+	w      *csv.Writer
+	lock   sync.Mutex
+	passed int
+	failed int
+}
+
+func NewVerifyReport(filename string) (*VerifyReport, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{f: f, w: csv.NewWriter(f)}
+	report.w.Write([]string{"shard_id", "from", "until", "tsm_file", "series",
+		"points", "status", "mismatches"})
+	return report, nil
+}
+
+func (report *VerifyReport) Add(verification *ShardVerification) error {
+	status := "pass"
+	if !verification.Passed() {
+		status = "fail"
+	}
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	if verification.Passed() {
+		report.passed = report.passed + 1
+	} else {
+		report.failed = report.failed + 1
+	}
+	shard := verification.Shard
+	return report.w.Write([]string{shard.id.String(),
+		shard.from.Format(time.RFC3339), shard.until.Format(time.RFC3339),
+		verification.File, fmt.Sprint(verification.Series),
+		fmt.Sprint(verification.Points), status,
+		strings.Join(verification.Mismatches, "; ")})
+}
+
+// Number of shards which passed and failed verification
+func (report *VerifyReport) Counts() (int, int) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	return report.passed, report.failed
+}
+
+func (report *VerifyReport) Close() error {
+	report.w.Flush()
+	if err := report.w.Error(); err != nil {
+		report.f.Close()
+		return err
+	}
+	return report.f.Close()
+}
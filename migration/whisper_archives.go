@@ -0,0 +1,237 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/uttamgandhi/graphite-influx/mapping"
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// How the archives of a whisper file are migrated
+const (
+	// One fetch per shard, whisper picks the single archive covering the range
+	ArchivesFetch = "fetch"
+	// Every archive is a series of its own, the measurement gets the
+	// resolution as suffix e.g. cpu_60s
+	ArchivesSeparate = "separate"
+	// The archives are stitched into one series using the highest resolution
+	// available for every period
+	ArchivesStitch = "stitch"
+)
+
+// Points of one whisper archive, sorted by timestamp
+type ArchivePoints struct {
+	archive whisper.ArchiveInfo
+	points  []whisper.Point
+}
+
+// A series of a whisper file and its points sorted by timestamp
+type WhisperSeries struct {
+	mtf    *mapping.MTF
+	points []whisper.Point
+}
+
+type byTimestamp []whisper.Point
+
+func (a byTimestamp) Len() int           { return len(a) }
+func (a byTimestamp) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTimestamp) Less(i, j int) bool { return a[i].Timestamp < a[j].Timestamp }
+
+// Read every archive of a whisper file, ordered from the highest resolution.
+// Only the valid points within the retention of the archive at now are kept,
+// the number of skipped null and stale slots is returned
+func ReadArchives(w *whisper.Whisper, now time.Time) ([]ArchivePoints, int, error) {
+	archives := make([]ArchivePoints, len(w.Header.Archives))
+	skipped := 0
+	for i, archive := range w.Header.Archives {
+		points, err := w.DumpArchive(i)
+		if err != nil {
+			return nil, 0, fmt.Errorf("read archive %d: %v", i, err)
+		}
+		until := uint32(now.Unix())
+		from := until - archive.Retention() + 1
+		var archiveSkipped int
+		archives[i].archive = archive
+		archives[i].points, archiveSkipped = mapping.ValidPoints(points,
+			archive.SecondsPerPoint, from, until)
+		skipped = skipped + archiveSkipped
+		sort.Sort(byTimestamp(archives[i].points))
+	}
+	sort.Sort(byResolution(archives))
+	return archives, skipped, nil
+}
+
+type byResolution []ArchivePoints
+
+func (a byResolution) Len() int      { return len(a) }
+func (a byResolution) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byResolution) Less(i, j int) bool {
+	return a[i].archive.SecondsPerPoint < a[j].archive.SecondsPerPoint
+}
+
+// Get the series of a whisper file for the separate and stitch archive modes
+func ArchiveSeries(archives []ArchivePoints, mtf *mapping.MTF, mode string) []WhisperSeries {
+	if mode == ArchivesSeparate {
+		series := make([]WhisperSeries, 0, len(archives))
+		for _, archive := range archives {
+			archiveMTF := *mtf
+			archiveMTF.Measurement = fmt.Sprintf("%s_%ds", mtf.Measurement,
+				archive.archive.SecondsPerPoint)
+			series = append(series, WhisperSeries{mtf: &archiveMTF,
+				points: archive.points})
+		}
+		return series
+	}
+	return []WhisperSeries{{mtf: mtf, points: StitchArchives(archives)}}
+}
+
+// Stitch the archives into one list of points, every archive contributes the
+// points older than the oldest point of the higher resolution archives
+func StitchArchives(archives []ArchivePoints) []whisper.Point {
+	var stitched []whisper.Point
+	cutoff := ^uint32(0)
+	for _, archive := range archives {
+		var older []whisper.Point
+		for _, point := range archive.points {
+			if point.Timestamp < cutoff {
+				older = append(older, point)
+			}
+		}
+		if len(older) == 0 {
+			continue
+		}
+		cutoff = older[0].Timestamp
+		stitched = append(older, stitched...)
+	}
+	return stitched
+}
+
+// Get the points in [from, until) of points sorted by timestamp
+func PointsInRange(points []whisper.Point, from time.Time, until time.Time) []whisper.Point {
+	start := sort.Search(len(points), func(i int) bool {
+		return int64(points[i].Timestamp) >= from.Unix()
+	})
+	end := sort.Search(len(points), func(i int) bool {
+		return int64(points[i].Timestamp) >= until.Unix()
+	})
+	return points[start:end]
+}
+
+// Downsample points with the step of their archive to the given resolution,
+// the points of a resolution interval are aggregated with the aggregation
+// method of the whisper file. Like whisper, an interval is only written when
+// the fraction of known points is at least xFilesFactor
+func Downsample(points []whisper.Point, step uint32, resolution uint32,
+	method whisper.AggregationMethod, xFilesFactor float32) []whisper.Point {
+
+	if step == 0 || resolution <= step {
+		return points
+	}
+	expected := float32(resolution / step)
+	var downsampled []whisper.Point
+	var values []float64
+	var intervalStart uint32
+	flush := func() {
+		if len(values) > 0 && float32(len(values))/expected >= xFilesFactor {
+			downsampled = append(downsampled, whisper.Point{
+				Timestamp: intervalStart, Value: Aggregate(method, values)})
+		}
+		values = values[:0]
+	}
+	for _, point := range points {
+		start := point.Timestamp - point.Timestamp%resolution
+		if start != intervalStart {
+			flush()
+			intervalStart = start
+		}
+		values = append(values, point.Value)
+	}
+	flush()
+	return downsampled
+}
+
+// Aggregate values the way whisper does for an aggregation method
+func Aggregate(method whisper.AggregationMethod, values []float64) float64 {
+	result := values[0]
+	switch method {
+	case whisper.AggregationSum, whisper.AggregationAverage:
+		for _, value := range values[1:] {
+			result = result + value
+		}
+		if method == whisper.AggregationAverage {
+			result = result / float64(len(values))
+		}
+	case whisper.AggregationLast:
+		result = values[len(values)-1]
+	case whisper.AggregationMax:
+		for _, value := range values[1:] {
+			if value > result {
+				result = value
+			}
+		}
+	case whisper.AggregationMin:
+		for _, value := range values[1:] {
+			if value < result {
+				result = value
+			}
+		}
+	}
+	return result
+}
+
+// Estimate the number of points of a whisper file in [from, until) from the
+// archives in its header, as migrated with the given archive mode
+func EstimatePoints(archives []whisper.ArchiveInfo, mode string,
+	downsample uint32, from time.Time, until time.Time, now time.Time) int64 {
+
+	sorted := make([]whisper.ArchiveInfo, len(archives))
+	copy(sorted, archives)
+	sort.Sort(byArchiveResolution(sorted))
+
+	var estimate int64
+	newer := now
+	for i, archive := range sorted {
+		step := int64(archive.SecondsPerPoint)
+		if int64(downsample) > step {
+			step = int64(downsample)
+		}
+		oldest := now.Add(-time.Duration(archive.Retention()) * time.Second)
+		switch mode {
+		case ArchivesSeparate:
+			estimate = estimate + overlapSeconds(from, until, oldest, now)/step
+		case ArchivesStitch:
+			estimate = estimate + overlapSeconds(from, until, oldest, newer)/step
+			newer = oldest
+		default:
+			//whisper fetches from the first archive which covers from
+			if !oldest.After(from) || i == len(sorted)-1 {
+				return overlapSeconds(from, until, oldest, now) / step
+			}
+		}
+	}
+	return estimate
+}
+
+// Seconds two time ranges overlap
+func overlapSeconds(from time.Time, until time.Time, otherFrom time.Time,
+	otherUntil time.Time) int64 {
+
+	if otherFrom.After(from) {
+		from = otherFrom
+	}
+	if otherUntil.Before(until) {
+		until = otherUntil
+	}
+	if !from.Before(until) {
+		return 0
+	}
+	return int64(until.Sub(from) / time.Second)
+}
+
+type byArchiveResolution []whisper.ArchiveInfo
+
+func (a byArchiveResolution) Len() int           { return len(a) }
+func (a byArchiveResolution) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byArchiveResolution) Less(i, j int) bool { return a[i].SecondsPerPoint < a[j].SecondsPerPoint }
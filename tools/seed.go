@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Write dummy data of a month, one point a day of November 2015, to a
+// database. The database has to be created in InfluxDB before
+func Seed(c client.Client, database string, measurement string) error {
+	// Create a new point batch
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:  database,
+		Precision: "s",
+	})
+	if err != nil {
+		return err
+	}
+
+	tags := map[string]string{"tag1": "value1"}
+	fields := map[string]interface{}{
+		"idle":   10.1,
+		"system": 53.3,
+		"user":   46.6,
+	}
+	const shortForm = "2006-Jan-02"
+	for i := 1; i < 31; i++ {
+		day := fmt.Sprintf("2015-Nov-%02d", i)
+		t, _ := time.Parse(shortForm, day)
+
+		pt, err := client.NewPoint(measurement, tags, fields, t)
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(pt)
+	}
+	return c.Write(bp)
+}
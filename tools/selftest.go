@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+)
+
+// Write a TSM file with a float, integer, boolean and string series in dir,
+// read every key back and compare the values, to check the TSM engine the
+// binary is built with
+func SelfTest(dir string) error {
+	filename := filepath.Join(dir, "selftest.tsm")
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("open TSM file: %v", err)
+	}
+	defer os.Remove(filename)
+	tsw, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("create TSM writer: %v", err)
+	}
+
+	// Keys in sorted order, as the TSM writer needs them
+	var data = []struct {
+		key    string
+		values []tsm1.Value
+	}{
+		{"bool", []tsm1.Value{
+			tsm1.NewValue(time.Unix(1, 0), true)},
+		},
+		{"cpu_usage,tag1=value1#!~#value", []tsm1.Value{
+			tsm1.NewValue(time.Unix(1, 0), 190.08),
+			tsm1.NewValue(time.Unix(2, 0), 190.09)},
+		},
+		{"int", []tsm1.Value{
+			tsm1.NewValue(time.Unix(1, 0), int64(1))},
+		},
+		{"string", []tsm1.Value{
+			tsm1.NewValue(time.Unix(1, 0), "foo")},
+		},
+	}
+
+	for _, d := range data {
+		if err := tsw.Write(d.key, d.values); err != nil {
+			f.Close()
+			return fmt.Errorf("write %v: %v", d.key, err)
+		}
+	}
+	if err := tsw.WriteIndex(); err != nil {
+		f.Close()
+		return fmt.Errorf("write TSM index: %v", err)
+	}
+	if err := tsw.Close(); err != nil {
+		return fmt.Errorf("write TSM close: %v", err)
+	}
+
+	f, err = os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("open TSM file: %v", err)
+	}
+	r, err := tsm1.NewTSMReaderWithOptions(
+		tsm1.TSMReaderOptions{
+			MMAPFile: f,
+		})
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("create TSM reader: %v", err)
+	}
+	defer r.Close()
+
+	for _, d := range data {
+		readValues, err := r.ReadAll(d.key)
+		if err != nil {
+			return fmt.Errorf("read %v: %v", d.key, err)
+		}
+		if exp := len(d.values); exp != len(readValues) {
+			return fmt.Errorf("read values length mismatch of %v: got %v, exp %v",
+				d.key, len(readValues), exp)
+		}
+		for i, v := range d.values {
+			if v.Value() != readValues[i].Value() {
+				return fmt.Errorf("read value mismatch of %v (%d): got %v, exp %v",
+					d.key, i, readValues[i].Value(), v.Value())
+			}
+			if v.UnixNano() != readValues[i].UnixNano() {
+				return fmt.Errorf("read time mismatch of %v (%d): got %v, exp %v",
+					d.key, i, readValues[i].Time(), v.Time())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Package tools has the small utilities of graphite-influx around single TSM
+// and whisper files and test data
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+)
+
+// Print the keys and time range of a TSM file, or the values of one key if
+// key is not empty, e.g. cpu_usage,tag2=value2#!~#idle
+func InspectTSM(w io.Writer, filename string, key string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("open TSM file: %v", err)
+	}
+
+	tsmReader, err := tsm1.NewTSMReaderWithOptions(
+		tsm1.TSMReaderOptions{
+			MMAPFile: f,
+		})
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("create TSM reader: %v", err)
+	}
+	defer tsmReader.Close()
+
+	if key == "" {
+		fmt.Fprintln(w, "Keys", tsmReader.Keys())
+		t1, t2 := tsmReader.TimeRange()
+		fmt.Fprintln(w, "TimeRange", t1, t2)
+		return nil
+	}
+
+	readValues, err := tsmReader.ReadAll(key)
+	if err != nil {
+		return fmt.Errorf("read %v: %v", key, err)
+	}
+	for i, value := range readValues {
+		fmt.Fprintln(w, "read value", i, value.Time().UTC(), value.Value())
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/influxdb/influxdb/tsdb/engine/tsm1"
+)
+
+// Write a TSM file with a single value for a key, e.g.
+// cpu_usage,tag2=value2#!~#idle
+func WriteTSMValue(filename string, key string, timestamp time.Time,
+	value float64) error {
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("open TSM file: %v", err)
+	}
+	defer file.Close()
+
+	tsmWriter, err := tsm1.NewTSMWriter(file)
+	if err != nil {
+		return fmt.Errorf("create TSM writer: %v", err)
+	}
+
+	values := []tsm1.Value{tsm1.NewValue(timestamp, value)}
+	if err := tsmWriter.Write(key, values); err != nil {
+		return fmt.Errorf("write TSM value: %v", err)
+	}
+	if err := tsmWriter.WriteIndex(); err != nil {
+		return fmt.Errorf("write TSM index: %v", err)
+	}
+	if err := tsmWriter.Close(); err != nil {
+		return fmt.Errorf("write TSM close: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/uttamgandhi24/whisper-go/whisper"
+)
+
+// Print the points of a whisper file in [from, until]
+func DumpWhisper(w io.Writer, filename string, from time.Time,
+	until time.Time) error {
+
+	wsp, err := whisper.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer wsp.Close()
+
+	interval, points, err := wsp.FetchUntil(uint32(from.Unix()),
+		uint32(until.Unix()))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Values in interval %+v\n", interval)
+	for i, p := range points {
+		fmt.Fprintf(w, "%d %v\n", i, p)
+	}
+	return nil
+}